@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// busyWindow is the rolling window the bus busy ratio is computed over
+const busyWindow = time.Minute
+
+// transaction is a span of time the bus was occupied
+type transaction struct {
+	start time.Time
+	end   time.Time
+}
+
+// busHandler is the shared handler doing the transport of a bus
+type busHandler interface {
+	modbus.Transporter
+	Connect() error
+	Close() error
+}
+
+// busyTransporter times the transactions of a shared bus. It serializes them
+// itself so time spent waiting for another slave is not counted as busy, and
+// so reconnecting the bus does not cut off a transaction.
+type busyTransporter struct {
+	handler busHandler
+	created time.Time
+
+	mu           sync.Mutex
+	transactions []transaction
+	last         time.Time // End of the last transaction
+}
+
+func newBusyTransporter(h busHandler) *busyTransporter {
+	return &busyTransporter{handler: h, created: time.Now()}
+}
+
+func (b *busyTransporter) Send(aduRequest []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	start := time.Now()
+	res, err := b.handler.Send(aduRequest)
+	end := time.Now()
+	b.prune(end)
+	b.transactions = append(b.transactions, transaction{start: start, end: end})
+	b.last = end
+	return res, err
+}
+
+// Connect opens the connection of the bus between transactions
+func (b *busyTransporter) Connect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.handler.Connect()
+}
+
+// Close closes the connection of the bus between transactions
+func (b *busyTransporter) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.handler.Close()
+}
+
+// idle returns how long ago the last transaction ended, or since the bus was
+// opened if there was none
+func (b *busyTransporter) idle() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last.IsZero() {
+		return time.Since(b.created)
+	}
+	return time.Since(b.last)
+}
+
+// prune drops the transactions that ended before the window. b.mu must be held.
+func (b *busyTransporter) prune(now time.Time) {
+	from := now.Add(-busyWindow)
+	i := 0
+	for i < len(b.transactions) && b.transactions[i].end.Before(from) {
+		i++
+	}
+	b.transactions = b.transactions[i:]
+}
+
+// ratio returns the fraction of the window the bus was busy, or of the time
+// since the bus was opened if that is shorter
+func (b *busyTransporter) ratio() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.prune(now)
+	from := now.Add(-busyWindow)
+	if b.created.After(from) {
+		from = b.created
+	}
+	var busy time.Duration
+	for _, t := range b.transactions {
+		start := t.start
+		if start.Before(from) {
+			start = from
+		}
+		busy += t.end.Sub(start)
+	}
+	window := now.Sub(from)
+	if window <= 0 {
+		return 0
+	}
+	return busy.Seconds() / window.Seconds()
+}
+
+// collector exports the busy ratio of the bus at address
+func (b *busyTransporter) collector(address string) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "modbus_bus_busy_ratio",
+		Help:        "Fraction of the last minute the bus was occupied by transactions",
+		ConstLabels: prometheus.Labels{"bus": address},
+	}, b.ratio)
+}
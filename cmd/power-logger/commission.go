@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/diebietse/power-logger/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+// setting is a meter setting to write when commissioning
+type setting struct {
+	name     string
+	value    int
+	register int
+}
+
+// settings returns the settings given on the command line. The register
+// of each is model specific, so it has to be given along with the value.
+func (c config) settings() ([]setting, error) {
+	var s []setting
+	for _, st := range []setting{
+		{name: "CT ratio", value: c.SetCT, register: c.CTReg},
+		{name: "PT ratio", value: c.SetPT, register: c.PTReg},
+	} {
+		if st.value < 0 {
+			continue
+		}
+		if st.value > 0xffff {
+			return nil, fmt.Errorf("invalid %v: %v", st.name, st.value)
+		}
+		if st.register < 0 || st.register > 0xffff {
+			return nil, fmt.Errorf("writing the %v needs the register holding it", st.name)
+		}
+		s = append(s, st)
+	}
+	return s, nil
+}
+
+// commission writes the settings to every device and verifies them
+func commission(settings []setting, loggers []*logger.Logger) error {
+	for _, l := range loggers {
+		for _, s := range settings {
+			if err := l.WriteRegister(uint16(s.register), uint16(s.value)); err != nil {
+				return fmt.Errorf("could not set %v of %v: %v", s.name, l.Name(), err)
+			}
+			log.Printf("Set %v of %v to %v", s.name, l.Name(), s.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/diebietse/power-logger/logger"
+)
+
+// config holds the command line options
+type config struct {
+	ConfigFile      string
+	Listeners       listenFlags
+	Web             webConfig
+	Dev             string
+	Serial          serialConfig
+	SlaveID         int
+	Timeout         time.Duration
+	DeviceName      string
+	Devices         deviceFlags
+	Discover        string
+	SlaveIDs        string
+	NominalVoltage  float64
+	SagThreshold    float64
+	SwellThreshold  float64
+	ResetErrors     bool
+	SetClock        bool
+	Telegraf        bool
+	Simulate        bool
+	FailFast        bool
+	SerialNameReg   int
+	FirmwareReg     int
+	PreReadWrite    string
+	ExposeRaw       bool
+	GrafanaURL      string
+	GrafanaToken    string
+	GrafanaStream   string
+	JSONLines       string
+	CSV             string
+	RotateSize      int64
+	RotateAge       time.Duration
+	Timestamps      bool
+	ReadOnScrape    time.Duration
+	PollRate        time.Duration
+	AlignPolls      bool
+	AveragePower    string
+	TCPKeepalive    time.Duration
+	SetCT           int
+	CTReg           int
+	SetPT           int
+	PTReg           int
+	LogLevel        string
+	LogFormat       string
+	TemperatureUnit string
+	MeterModel      string
+	RegType         string
+	FilterWindow    int
+	FilterCurrent   float64
+	ZeroStart       bool
+	Scales          scaleFlags
+	Disabled        string
+	Hold            channelsFlag
+	ZeroAfter       int
+	MaxRegs         int
+	Kilo            bool
+	LegacyNames     bool
+	RetryJitter     time.Duration
+}
+
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", "", "YAML file mapping flag names to values, used for the flags not given on the command line.")
+	fs.Var(&c.Listeners, "addr", "TCP address to listen on, optionally followed by = and the comma separated endpoints to serve there (e.g. 127.0.0.1:8081=/metrics). Repeat to listen on more addresses. (default \":8080\")")
+	fs.StringVar(&c.Web.User, "authUser", "", "Require HTTP Basic Auth with this user name, set together with -authPass.")
+	fs.StringVar(&c.Web.Password, "authPass", "", "Password for -authUser.")
+	fs.StringVar(&c.Web.CertFile, "tlsCert", "", "Serve HTTPS with this PEM certificate file, set together with -tlsKey.")
+	fs.StringVar(&c.Web.KeyFile, "tlsKey", "", "PEM private key file for -tlsCert.")
+	fs.StringVar(&c.AveragePower, "averagePower", "", "Comma separated windows (e.g. 15m,1h) to export the average active power over as mains_average_power_w.")
+	fs.StringVar(&c.Dev, "dev", "/dev/ttyS0", "TTY device to use.")
+	fs.IntVar(&c.Serial.BaudRate, "baud", 9600, "Baud rate of the serial buses.")
+	fs.IntVar(&c.Serial.DataBits, "dataBits", 8, "Data bits of the serial buses.")
+	fs.StringVar(&c.Serial.Parity, "parity", "N", "Parity of the serial buses: N, E or O.")
+	fs.IntVar(&c.Serial.StopBits, "stopBits", 1, "Stop bits of the serial buses: 1 or 2.")
+	fs.DurationVar(&c.Timeout, "timeout", 5*time.Second, "Timeout of every Modbus transaction, raise it for long serial buses.")
+	fs.IntVar(&c.SlaveID, "slaveId", 1, "Slave ID of the meter on -dev.")
+	fs.StringVar(&c.DeviceName, "deviceName", "flat-power", "Set the device_name label.")
+	fs.Var(&c.Devices, "device", "Poll a device given as name=<name>,transport=<rtu|tcp>,address=<tty or host:port>,slave=<id>. Repeat for more devices, replaces -dev and -deviceName.")
+	fs.StringVar(&c.SlaveIDs, "slaveIds", "", "Poll these slave IDs (e.g. 1-4) on -dev without probing them, naming the devices <deviceName>-<slave ID>.")
+	fs.StringVar(&c.Discover, "discover", "", "Probe these slave IDs (e.g. 1-247) and poll every meter found.")
+	fs.Float64Var(&c.NominalVoltage, "nominalVoltage", 230, "Nominal mains voltage, readings more than 15% off are discarded.")
+	fs.Float64Var(&c.SagThreshold, "sagThreshold", 0.9, "Fraction of the nominal voltage below which a voltage sag is counted.")
+	fs.Float64Var(&c.SwellThreshold, "swellThreshold", 1.1, "Fraction of the nominal voltage above which a voltage swell is counted.")
+	fs.DurationVar(&c.RetryJitter, "retryJitter", 50*time.Millisecond, "Upper bound of the random delay before retrying a read, so devices on a shared bus do not retry in lockstep.")
+	fs.BoolVar(&c.ResetErrors, "resetErrors", false, "Enable the /reset-errors endpoint.")
+	fs.BoolVar(&c.SetClock, "setClock", false, "Enable the /set-clock endpoint that writes the device clock.")
+	fs.IntVar(&c.SerialNameReg, "serialNameReg", -1, "Name devices after the 32 bit serial number at this holding register, -1 to use the configured names.")
+	fs.BoolVar(&c.AlignPolls, "alignPolls", false, "Poll at multiples of -pollRate on the wall clock, such as :00, :10 and :20, so the readings of several loggers line up.")
+	fs.DurationVar(&c.PollRate, "pollRate", 10*time.Second, "Interval to read the meters at, at least 1s.")
+	fs.StringVar(&c.PreReadWrite, "preReadWrite", "", "Write a holding register before every read, given as register=value (e.g. 0x60=1), for meters that need a command to measure.")
+	fs.BoolVar(&c.ExposeRaw, "exposeRaw", false, "Export the raw register values as mains_raw_register and serve /debug/filter for debugging.")
+	fs.StringVar(&c.GrafanaURL, "grafanaURL", "", "Push every reading to Grafana Live on this Grafana server.")
+	fs.StringVar(&c.GrafanaToken, "grafanaToken", "", "API token for pushing to Grafana Live.")
+	fs.StringVar(&c.GrafanaStream, "grafanaStream", "power-logger", "Grafana Live stream to push to.")
+	fs.StringVar(&c.CSV, "csv", "", "Append every reading as a row to this CSV file.")
+	fs.StringVar(&c.JSONLines, "jsonl", "", "Append every reading as a line of JSON to this file.")
+	fs.Int64Var(&c.RotateSize, "rotateSize", 0, "Rotate reading files larger than this many bytes, 0 to disable.")
+	fs.DurationVar(&c.RotateAge, "rotateAge", 0, "Rotate reading files older than this, 0 to disable.")
+	fs.DurationVar(&c.ReadOnScrape, "readOnScrape", 0, "Read the meters when scraped instead of every poll interval, reusing reads younger than this. 0 to poll.")
+	fs.BoolVar(&c.Timestamps, "timestamps", false, "Export measurements with the time they were read instead of the scrape time.")
+	fs.StringVar(&c.LogLevel, "logLevel", "info", "Log level: trace, debug, info, warn or error.")
+	fs.StringVar(&c.LogFormat, "logFormat", "text", "Log format: text or json.")
+	fs.Float64Var(&c.FilterCurrent, "energyFilterMaxCurrent", 100, "Current in A the energy filter allows for, energy increasing faster than this draws at the nominal voltage is discarded.")
+	fs.IntVar(&c.FilterWindow, "energyFilterWindow", 0, "Accept an energy reading after this many consecutive polls were discarded by the energy filter, 0 to never do so.")
+	fs.StringVar(&c.Disabled, "disableChannels", "", "Comma separated channels the meter does not have (e.g. temperature,reactive_power), which are neither read nor exported.")
+	fs.Var(&c.Hold, "holdChannels", "Comma separated channels that keep their last value on read errors, the others are zeroed. Defaults to the energy channels of -meterModel, set it empty to zero all.")
+	fs.BoolVar(&c.FailFast, "failFast", false, "Exit if the first read of any device fails, rather than serving zeroed metrics while retrying.")
+	fs.IntVar(&c.MaxRegs, "maxRegs", 39, "Most registers to read per request, for gateways limiting the Modbus response size. Larger register blocks are split.")
+	fs.IntVar(&c.ZeroAfter, "zeroAfter", 1, "Zero the channels not in -holdChannels after this many consecutive failed reads, keeping their last values until then.")
+	fs.BoolVar(&c.Kilo, "kilo", false, "Also export the active, reactive and apparent power in kW, kvar and kVA.")
+	c.Scales = scaleFlags{}
+	fs.Var(c.Scales, "scale", "Override the scale a channel's raw value is divided by, given as channel=scale (e.g. current=100). Repeat or separate with commas for more channels.")
+	fs.BoolVar(&c.ZeroStart, "energyZeroStart", false, "Trust energy readings of zero at startup, for freshly reset meters. By default the energy filter waits for the first non-zero reading.")
+	fs.BoolVar(&c.LegacyNames, "legacyMetricNames", true, "Also export renamed metrics under their former names, such as mains_appartent_power_va for mains_apparent_power_va, until queries are migrated.")
+	fs.StringVar(&c.MeterModel, "meterModel", "d113003", "Meter model whose register map to use: "+strings.Join(logger.Models(), ", ")+".")
+	fs.StringVar(&c.RegType, "regType", "holding", "Registers to read the measurements from: holding, or input for meters answering function code 4.")
+	fs.StringVar(&c.TemperatureUnit, "temperatureUnit", "c", "Device temperature unit: c for Celsius or f for Fahrenheit.")
+	fs.IntVar(&c.SetCT, "setCT", -1, "Write this CT ratio to the register given by -ctReg of every device, verify it and exit.")
+	fs.IntVar(&c.CTReg, "ctReg", -1, "Holding register of the CT ratio setting, see the meter manual. Also exported in mains_meter_info.")
+	fs.IntVar(&c.FirmwareReg, "firmwareReg", -1, "Holding register of the firmware version to export in mains_meter_info, see the meter manual.")
+	fs.IntVar(&c.SetPT, "setPT", -1, "Write this PT ratio to the register given by -ptReg of every device, verify it and exit.")
+	fs.IntVar(&c.PTReg, "ptReg", -1, "Holding register of the PT ratio setting, see the meter manual.")
+	fs.DurationVar(&c.TCPKeepalive, "tcpKeepalive", 0, "Read a register over TCP connections idle for this long and reconnect them if it fails, 0 to disable.")
+	fs.BoolVar(&c.Simulate, "simulate", false, "Poll simulated meters instead of the configured buses, to try out dashboards without hardware.")
+	fs.BoolVar(&c.Telegraf, "telegraf", false, "Read every device once, print the readings as InfluxDB line protocol and exit.")
+}
+
+// channelsFlag is a comma separated list of channels, which unlike an empty
+// string flag tells an explicitly empty list from an unset one
+type channelsFlag struct {
+	channels []string
+	set      bool
+}
+
+func (c *channelsFlag) String() string {
+	return strings.Join(c.channels, ",")
+}
+
+func (c *channelsFlag) Set(s string) error {
+	c.channels = nil
+	if s != "" {
+		c.channels = strings.Split(s, ",")
+	}
+	c.set = true
+	return nil
+}
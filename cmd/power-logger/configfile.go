@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// repeatableFlags are the flags set once per item of a list in a config
+// file. The items of a list for any other flag are joined with commas.
+var repeatableFlags = map[string]bool{"addr": true, "device": true, "scale": true}
+
+// loadConfigFile sets the flags of fs not given on the command line from the
+// YAML file at path. The file maps flag names to their values. A list sets a
+// repeatable flag such as addr or device once per item, and is passed comma
+// separated to the others, such as holdChannels. A map is passed as comma
+// separated key=value pairs, such as for device and scale.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid config file %v: %v", path, err)
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q in config file %v", name, path)
+		}
+		if set[name] {
+			continue
+		}
+		items, ok := values[name].([]any)
+		if !ok {
+			items = []any{values[name]}
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, err := configValue(item)
+			if err != nil {
+				return fmt.Errorf("invalid %v in config file %v: %v", name, path, err)
+			}
+			list = append(list, s)
+		}
+		if !repeatableFlags[name] {
+			list = []string{strings.Join(list, ",")}
+		}
+		for _, s := range list {
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("invalid %v in config file %v: %v", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValue returns the flag value of a config file item
+func configValue(item any) (string, error) {
+	switch v := item.(type) {
+	case nil:
+		return "", fmt.Errorf("no value")
+	case []any:
+		return "", fmt.Errorf("nested list")
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for key, value := range v {
+			s, err := configValue(value)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
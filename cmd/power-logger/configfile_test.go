@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFileLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte("holdChannels: [active_energy, reactive_energy]\naddr: [':8080', '127.0.0.1:8081']\n")
+	assert.NoError(t, os.WriteFile(path, data, 0o600), "Could not write config file")
+
+	cfg := config{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.registerFlags(fs)
+	assert.NoError(t, fs.Parse(nil), "Could not parse flags")
+	assert.NoError(t, loadConfigFile(fs, path), "Could not load config file")
+	assert.Equal(t, []string{"active_energy", "reactive_energy"}, cfg.Hold.channels, "List not joined for holdChannels")
+	assert.Len(t, cfg.Listeners, 2, "List not repeated for addr")
+}
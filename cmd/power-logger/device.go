@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diebietse/power-logger/logger"
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	transportRTU = "rtu"
+	transportTCP = "tcp"
+)
+
+// deviceConfig describes a meter and how to reach it
+type deviceConfig struct {
+	Name      string
+	Transport string
+	Address   string
+	SlaveID   byte
+}
+
+// deviceFlags collects repeated -device flags
+type deviceFlags []deviceConfig
+
+func (d *deviceFlags) String() string {
+	var s []string
+	for _, c := range *d {
+		s = append(s, fmt.Sprintf("name=%v,transport=%v,address=%v,slave=%d", c.Name, c.Transport, c.Address, c.SlaveID))
+	}
+	return strings.Join(s, " ")
+}
+
+// Set parses a device given as comma separated key=value pairs
+func (d *deviceFlags) Set(s string) error {
+	c := deviceConfig{Transport: transportRTU, SlaveID: 1}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid device option %q, expected key=value", pair)
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			c.Name = value
+		case "transport":
+			c.Transport = value
+		case "address":
+			c.Address = value
+		case "slave":
+			id, err := parseSlaveID(value)
+			if err != nil {
+				return err
+			}
+			c.SlaveID = byte(id)
+		default:
+			return fmt.Errorf("unknown device option %q", key)
+		}
+	}
+	if c.Name == "" || c.Address == "" {
+		return fmt.Errorf("device %q needs a name and an address", s)
+	}
+	if c.Transport != transportRTU && c.Transport != transportTCP {
+		return fmt.Errorf("unknown transport %q, expected %v or %v", c.Transport, transportRTU, transportTCP)
+	}
+	for _, existing := range *d {
+		if existing.Name == c.Name {
+			return fmt.Errorf("duplicate device name %q", c.Name)
+		}
+	}
+	*d = append(*d, c)
+	return nil
+}
+
+// buses opens every serial port and TCP connection once and hands out a
+// client per slave on it
+type buses struct {
+	rtu          map[string]*modbus.RTUClientHandler
+	tcp          map[string]*modbus.TCPClientHandler
+	tcpSlave     map[string]byte // A slave on each TCP bus, for keepalive reads
+	busy         map[string]*busyTransporter
+	reconnectors map[string]*logger.Reconnector
+	reconnects   *prometheus.CounterVec
+	serial       serialConfig
+	timeout      time.Duration
+	backoff      time.Duration // Initial delay between reconnects after failed polls
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func newBuses(serial serialConfig, timeout, backoff time.Duration) (*buses, error) {
+	reconnects := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "modbus_bus_reconnects_total",
+		Help: "Reconnects of a bus after repeated failed polls or a failed keepalive read",
+	}, []string{"bus"})
+	if err := prometheus.Register(reconnects); err != nil {
+		return nil, err
+	}
+	return &buses{
+		serial:       serial,
+		timeout:      timeout,
+		backoff:      backoff,
+		rtu:          map[string]*modbus.RTUClientHandler{},
+		tcp:          map[string]*modbus.TCPClientHandler{},
+		tcpSlave:     map[string]byte{},
+		busy:         map[string]*busyTransporter{},
+		reconnectors: map[string]*logger.Reconnector{},
+		reconnects:   reconnects,
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+// addBusy times the transactions on the bus at address and reconnects it
+// when its polls keep failing
+func (b *buses) addBusy(address string, h busHandler) error {
+	busy := newBusyTransporter(h)
+	if err := prometheus.Register(busy.collector(address)); err != nil {
+		return err
+	}
+	b.busy[address] = busy
+	b.reconnectors[address] = logger.NewReconnector(busy, address, b.backoff, b.reconnects.WithLabelValues(address))
+	return nil
+}
+
+func (b *buses) rtuHandler(address string) (*modbus.RTUClientHandler, error) {
+	if h, ok := b.rtu[address]; ok {
+		return h, nil
+	}
+	// Modbus RTU/ASCII
+	h := modbus.NewRTUClientHandler(address)
+	h.BaudRate = b.serial.BaudRate
+	h.DataBits = b.serial.DataBits
+	h.Parity = b.serial.Parity
+	h.StopBits = b.serial.StopBits
+	h.SlaveId = 1
+	h.Timeout = b.timeout
+	if err := h.Connect(); err != nil {
+		return nil, err
+	}
+	if err := b.addBusy(address, h); err != nil {
+		h.Close()
+		return nil, err
+	}
+	b.rtu[address] = h
+	return h, nil
+}
+
+func (b *buses) tcpHandler(address string) (*modbus.TCPClientHandler, error) {
+	if h, ok := b.tcp[address]; ok {
+		return h, nil
+	}
+	h := modbus.NewTCPClientHandler(address)
+	h.SlaveId = 1
+	h.Timeout = b.timeout
+	if err := h.Connect(); err != nil {
+		return nil, err
+	}
+	if err := b.addBusy(address, h); err != nil {
+		h.Close()
+		return nil, err
+	}
+	b.tcp[address] = h
+	return h, nil
+}
+
+// client returns a client addressing the slave of d. The packager only
+// frames requests for the slave, the shared handler does the transport,
+// timed by its busyTransporter.
+func (b *buses) client(d deviceConfig) (modbus.Client, error) {
+	switch d.Transport {
+	case transportTCP:
+		if _, err := b.tcpHandler(d.Address); err != nil {
+			return nil, err
+		}
+		if _, ok := b.tcpSlave[d.Address]; !ok {
+			b.tcpSlave[d.Address] = d.SlaveID
+		}
+		packager := modbus.NewTCPClientHandler(d.Address)
+		packager.SlaveId = d.SlaveID
+		return modbus.NewClient2(packager, b.busy[d.Address]), nil
+	default:
+		if _, err := b.rtuHandler(d.Address); err != nil {
+			return nil, err
+		}
+		packager := modbus.NewRTUClientHandler(d.Address)
+		packager.SlaveId = d.SlaveID
+		return modbus.NewClient2(packager, b.busy[d.Address]), nil
+	}
+}
+
+// reconnector returns the Reconnector shared by the slaves on the bus of d,
+// which must have a client
+func (b *buses) reconnector(d deviceConfig) *logger.Reconnector {
+	return b.reconnectors[d.Address]
+}
+
+// Close closes all serial ports and TCP connections
+func (b *buses) Close() {
+	close(b.stop)
+	b.wg.Wait()
+	for address, h := range b.rtu {
+		if err := h.Close(); err != nil {
+			log.Errorf("Could not close %v: %v", address, err)
+		}
+	}
+	for address, h := range b.tcp {
+		if err := h.Close(); err != nil {
+			log.Errorf("Could not close %v: %v", address, err)
+		}
+	}
+}
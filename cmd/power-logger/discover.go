@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/diebietse/power-logger/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+// discover probes every slave ID on the serial bus at address and returns
+// those with a supported meter, reading them as set by opts
+func discover(b *buses, address string, ids []byte, opts ...logger.Option) ([]byte, error) {
+	var found []byte
+	for _, id := range ids {
+		client, err := b.client(deviceConfig{Transport: transportRTU, Address: address, SlaveID: id})
+		if err != nil {
+			return nil, err
+		}
+		model, err := logger.Probe(client, opts...)
+		if err != nil {
+			log.Debugf("No meter at ID %d: %v", id, err)
+			continue
+		}
+		log.Printf("Found meter at ID %d, looks like %v", id, model)
+		found = append(found, id)
+	}
+	return found, nil
+}
+
+// parseSlaveIDs parses a comma separated list of slave IDs and ID ranges,
+// such as "1,3,10-20"
+func parseSlaveIDs(s string) ([]byte, error) {
+	var ids []byte
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			to = from
+		}
+		first, err := parseSlaveID(from)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parseSlaveID(to)
+		if err != nil {
+			return nil, err
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid slave ID range: %v", part)
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, byte(id))
+		}
+	}
+	return ids, nil
+}
+
+func parseSlaveID(s string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || id < 1 || id > 247 {
+		return 0, fmt.Errorf("invalid slave ID: %q", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/diebietse/power-logger/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+func postOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Could not encode response: %v", err)
+	}
+}
+
+// selectLoggers returns the loggers matching the "device" form value, or all
+// of them if it is not given
+func selectLoggers(r *http.Request, loggers []*logger.Logger) []*logger.Logger {
+	name := r.FormValue("device")
+	if name == "" {
+		return loggers
+	}
+	for _, l := range loggers {
+		if l.Name() == name {
+			return []*logger.Logger{l}
+		}
+	}
+	return nil
+}
+
+// resetErrorsHandler zeroes sensor_read_errors_count. Anything computing
+// rate() or increase() over the metric will see the reset as a drop.
+func resetErrorsHandler(loggers []*logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !postOnly(w, r) {
+			return
+		}
+		selected := selectLoggers(r, loggers)
+		if len(selected) == 0 {
+			http.Error(w, "unknown device", http.StatusNotFound)
+			return
+		}
+		for _, l := range selected {
+			l.ResetErrors()
+			log.Printf("Read error count of %v reset by %v", l.Name(), r.RemoteAddr)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+type filterResponse struct {
+	Device   string               `json:"device"`
+	Channels []logger.FilterState `json:"channels"`
+}
+
+// filterHandler returns the energy filter state of the selected devices
+func filterHandler(loggers []*logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected := selectLoggers(r, loggers)
+		if len(selected) == 0 {
+			http.Error(w, "unknown device", http.StatusNotFound)
+			return
+		}
+		resp := []filterResponse{}
+		for _, l := range selected {
+			resp = append(resp, filterResponse{Device: l.Name(), Channels: l.FilterStates()})
+		}
+		writeJSON(w, resp)
+	})
+}
+
+// snapshotHandler returns the last reading of the selected devices
+func snapshotHandler(loggers []*logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selected := selectLoggers(r, loggers)
+		if len(selected) == 0 {
+			http.Error(w, "unknown device", http.StatusNotFound)
+			return
+		}
+		resp := []logger.Reading{}
+		for _, l := range selected {
+			resp = append(resp, l.Snapshot())
+		}
+		writeJSON(w, resp)
+	})
+}
+
+// healthHandler reports that the process is up and serving
+func healthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// readyHandler reports ready once any of the devices was read recently
+func readyHandler(loggers []*logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, l := range loggers {
+			if l.Ready() {
+				fmt.Fprintln(w, "ok")
+				return
+			}
+		}
+		http.Error(w, "no device read recently", http.StatusServiceUnavailable)
+	})
+}
+
+type setClockResponse struct {
+	Device string    `json:"device"`
+	Before time.Time `json:"before"`
+	After  time.Time `json:"after"`
+}
+
+// setClockHandler sets the device clock to the RFC 3339 "time" form value,
+// or to the current server time if it is not given
+func setClockHandler(loggers []*logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !postOnly(w, r) {
+			return
+		}
+		target := time.Now()
+		if v := r.FormValue("time"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid time: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			target = t
+		}
+		selected := selectLoggers(r, loggers)
+		if len(selected) == 0 {
+			http.Error(w, "unknown device", http.StatusNotFound)
+			return
+		}
+
+		resp := []setClockResponse{}
+		for _, l := range selected {
+			c := setClockResponse{Device: l.Name()}
+			var err error
+			if c.Before, err = l.Clock(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			if err = l.SetClock(target); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			if c.After, err = l.Clock(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			log.Printf("Clock of %v set from %v to %v by %v", c.Device, c.Before, c.After, r.RemoteAddr)
+			resp = append(resp, c)
+		}
+		writeJSON(w, resp)
+	})
+}
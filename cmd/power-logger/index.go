@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<html>
+<head><title>power-logger</title></head>
+<body>
+<h1>power-logger</h1>
+<p>Devices:{{range .Devices}} {{.}}{{end}}</p>
+<ul>
+{{range .Endpoints}}<li><a href="{{.Path}}">{{.Path}}</a> - {{.Description}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type endpoint struct {
+	Path        string
+	Description string
+	handler     http.Handler
+}
+
+// index collects the HTTP handlers and serves a page linking all of them
+type index struct {
+	Devices   []string
+	Endpoints []endpoint
+}
+
+func (i *index) handle(path, description string, handler http.Handler) {
+	i.Endpoints = append(i.Endpoints, endpoint{Path: path, Description: description, handler: handler})
+}
+
+// mux returns a mux serving the endpoints at paths, or all endpoints if no
+// paths are given, along with an index page linking them
+func (i *index) mux(paths []string) (*http.ServeMux, error) {
+	selected := &index{Devices: i.Devices}
+	if len(paths) == 0 {
+		selected.Endpoints = i.Endpoints
+	}
+	for _, p := range paths {
+		found := false
+		for _, e := range i.Endpoints {
+			if e.Path == p {
+				selected.Endpoints = append(selected.Endpoints, e)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown endpoint %q", p)
+		}
+	}
+
+	mux := http.NewServeMux()
+	for _, e := range selected.Endpoints {
+		mux.Handle(e.Path, e.handler)
+	}
+	mux.Handle("/", selected)
+	return mux, nil
+}
+
+func (i *index) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, i); err != nil {
+		log.Errorf("Could not render index: %v", err)
+	}
+}
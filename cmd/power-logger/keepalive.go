@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/goburrow/modbus"
+	log "github.com/sirupsen/logrus"
+)
+
+// startKeepalive reads a register over every TCP connection that was idle
+// for interval, so firewalls and NAT gateways do not silently drop it. A
+// connection failing the read is reconnected rather than failing the next
+// poll. Call it once all clients are created.
+func (b *buses) startKeepalive(interval time.Duration) {
+	for address := range b.tcp {
+		b.wg.Add(1)
+		go b.keepalive(address, interval)
+	}
+}
+
+func (b *buses) keepalive(address string, interval time.Duration) {
+	defer b.wg.Done()
+	packager := modbus.NewTCPClientHandler(address)
+	packager.SlaveId = b.tcpSlave[address]
+	client := modbus.NewClient2(packager, b.busy[address])
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+		if b.busy[address].idle() < interval {
+			continue
+		}
+		_, err := client.ReadHoldingRegisters(0, 1)
+		var exception *modbus.ModbusError
+		if err == nil || errors.As(err, &exception) {
+			// An exception response still shows the connection is up
+			continue
+		}
+		log.Warnf("Keepalive read on %v failed, reconnecting: %v", address, err)
+		if err := b.reconnectors[address].Reconnect(); err != nil {
+			log.Errorf("Could not reconnect %v: %v", address, err)
+		}
+	}
+}
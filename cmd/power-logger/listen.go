@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAddr     = ":8080"
+	shutdownTimeout = 5 * time.Second // Time given to in-flight requests on shutdown
+)
+
+// listener is an address to serve HTTP on and the endpoints served there
+type listener struct {
+	Addr  string
+	Paths []string
+}
+
+// webConfig holds the optional credentials and certificate all listeners
+// are served with
+type webConfig struct {
+	User     string
+	Password string
+	CertFile string
+	KeyFile  string
+}
+
+func (w webConfig) validate() error {
+	if (w.User == "") != (w.Password == "") {
+		return errors.New("-authUser and -authPass must be set together")
+	}
+	if (w.CertFile == "") != (w.KeyFile == "") {
+		return errors.New("-tlsCert and -tlsKey must be set together")
+	}
+	return nil
+}
+
+// handler wraps h to require the configured credentials, if any
+func (w webConfig) handler(h http.Handler) http.Handler {
+	if w.User == "" {
+		return h
+	}
+	user := sha256.Sum256([]byte(w.User))
+	password := sha256.Sum256([]byte(w.Password))
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		gotUser := sha256.Sum256([]byte(u))
+		gotPassword := sha256.Sum256([]byte(p))
+		// Compare both in constant time so neither leaks through the timing
+		userOK := subtle.ConstantTimeCompare(gotUser[:], user[:]) == 1
+		passwordOK := subtle.ConstantTimeCompare(gotPassword[:], password[:]) == 1
+		if !ok || !userOK || !passwordOK {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="power-logger", charset="UTF-8"`)
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// listenAndServe serves srv over HTTPS if a certificate is configured
+func (w webConfig) listenAndServe(srv *http.Server) error {
+	if w.CertFile != "" {
+		return srv.ListenAndServeTLS(w.CertFile, w.KeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+// listenFlags collects repeated -addr flags
+type listenFlags []listener
+
+func (l *listenFlags) String() string {
+	var s []string
+	for _, a := range *l {
+		if len(a.Paths) == 0 {
+			s = append(s, a.Addr)
+			continue
+		}
+		s = append(s, a.Addr+"="+strings.Join(a.Paths, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+// Set parses an address optionally followed by "=" and a comma separated
+// list of the endpoints to serve on it
+func (l *listenFlags) Set(s string) error {
+	addr, paths, hasPaths := strings.Cut(s, "=")
+	a := listener{Addr: addr}
+	if hasPaths {
+		for _, p := range strings.Split(paths, ",") {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("invalid endpoint %q in %q", p, s)
+			}
+			a.Paths = append(a.Paths, p)
+		}
+	}
+	*l = append(*l, a)
+	return nil
+}
+
+// serve serves the endpoints of idx on every listener and returns when the
+// first of them fails, or once ctx is done and the servers are shut down
+func serve(ctx context.Context, listeners []listener, web webConfig, idx *index) error {
+	if len(listeners) == 0 {
+		listeners = []listener{{Addr: defaultAddr}}
+	}
+	errs := make(chan error, len(listeners))
+	var servers []*http.Server
+	for _, l := range listeners {
+		mux, err := idx.mux(l.Paths)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Addr: l.Addr, Handler: web.handler(mux)}
+		servers = append(servers, srv)
+		log.Printf("Starting server: %v", l.Addr)
+		go func() {
+			errs <- fmt.Errorf("server on %v: %v", srv.Addr, web.listenAndServe(srv))
+		}()
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("Could not shut down server on %v: %v", srv.Addr, err)
+		}
+	}
+	return nil
+}
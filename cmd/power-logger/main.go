@@ -1,50 +1,403 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"net/http"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/diebietse/power-logger/logger"
 	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
 func main() {
-	addr := flag.String("addr", ":8080", "TCP address to listen on.")
-	dev := flag.String("dev", "/dev/ttyS0", "TTY device to use.")
-	deviceName := flag.String("deviceName", "flat-power", "Set the device_name label.")
+	cfg := config{}
+	cfg.registerFlags(flag.CommandLine)
 	flag.Parse()
+	if cfg.ConfigFile != "" {
+		if err := loadConfigFile(flag.CommandLine, cfg.ConfigFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// Modbus RTU/ASCII
-	handler := modbus.NewRTUClientHandler(*dev)
-	handler.BaudRate = 9600
-	handler.DataBits = 8
-	handler.Parity = "N"
-	handler.StopBits = 1
-	handler.SlaveId = 1
-	handler.Timeout = 5 * time.Second
+	if err := configureLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+	// Stopping the servers lets run close the loggers and then the buses
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, cfg config) error {
+	startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "powerlogger_start_time_seconds",
+		Help: "Start time of the process since the Unix epoch",
+	})
+	startTime.SetToCurrentTime()
+	if err := prometheus.Register(startTime); err != nil {
+		return err
+	}
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "mains_build_info",
+		Help:        "Version of the power-logger build, always 1",
+		ConstLabels: prometheus.Labels{"version": version, "commit": commit, "go_version": runtime.Version()},
+	})
+	buildInfo.Set(1)
+	if err := prometheus.Register(buildInfo); err != nil {
+		return err
+	}
 
-	err := handler.Connect()
+	settings, err := cfg.settings()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if err := cfg.Serial.validate(); err != nil {
+		return err
+	}
+	if cfg.SlaveID < 1 || cfg.SlaveID > 247 {
+		return fmt.Errorf("invalid slave ID: %v", cfg.SlaveID)
 	}
-	defer handler.Close()
 
-	http.Handle("/metrics", promhttp.Handler())
+	switch cfg.RegType {
+	case "holding", "input":
+	default:
+		return fmt.Errorf("unknown register type %q, expected holding or input", cfg.RegType)
+	}
 
-	client := modbus.NewClient(handler)
-	l, err := logger.New(client, *deviceName)
+	if err := cfg.Web.validate(); err != nil {
+		return err
+	}
+
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid timeout: %v", cfg.Timeout)
+	}
+
+	b, err := newBuses(cfg.Serial, cfg.Timeout, cfg.PollRate)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer l.Close()
-	l.Poller()
+	defer b.Close()
 
-	log.Printf("Starting server: %v", *addr)
-	err = http.ListenAndServe(*addr, nil)
+	devices, err := resolveDevices(cfg, b)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	var sinkOpts []logger.Option
+	if cfg.GrafanaURL != "" {
+		g := logger.NewGrafanaLive(cfg.GrafanaURL, cfg.GrafanaToken, cfg.GrafanaStream)
+		defer g.Close()
+		sinkOpts = append(sinkOpts, logger.WithSink(g))
+	}
+	if cfg.JSONLines != "" {
+		j, err := logger.NewJSONLines(cfg.JSONLines, cfg.RotateSize, cfg.RotateAge)
+		if err != nil {
+			return err
+		}
+		defer j.Close()
+		sinkOpts = append(sinkOpts, logger.WithSink(j))
+	}
+
+	model, registers, err := registerMap(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.CSV != "" {
+		c, err := logger.NewCSV(cfg.CSV, registers.Channels(), cfg.RotateSize, cfg.RotateAge)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		sinkOpts = append(sinkOpts, logger.WithSink(c))
+	}
+
+	var averageWindows []time.Duration
+	if cfg.AveragePower != "" {
+		for _, s := range strings.Split(cfg.AveragePower, ",") {
+			w, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("invalid -averagePower window %q: %v", s, err)
+			}
+			averageWindows = append(averageWindows, w)
+		}
+	}
+
+	var preReadWrite []logger.Option
+	if cfg.PreReadWrite != "" {
+		address, value, err := parseRegisterWrite(cfg.PreReadWrite)
+		if err != nil {
+			return err
+		}
+		preReadWrite = append(preReadWrite, logger.WithPreReadWrite(address, value))
+	}
+
+	var loggers []*logger.Logger
+	for _, d := range devices {
+		var client modbus.Client = logger.NewSimulator()
+		var opts []logger.Option
+		if !cfg.Simulate {
+			client, err = b.client(d)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, logger.WithReconnect(b.reconnector(d)))
+		}
+		opts = append(opts,
+			logger.WithMeterInfo(logger.MeterInfo{Model: model, SlaveID: strconv.Itoa(int(d.SlaveID)), Transport: d.Transport}),
+			logger.WithRegisterMap(registers),
+			logger.WithNominalVoltage(cfg.NominalVoltage),
+			logger.WithEnergyFilterWindow(cfg.FilterWindow),
+			logger.WithEnergyFilterMaxCurrent(cfg.FilterCurrent),
+			logger.WithRetryJitter(cfg.RetryJitter),
+			logger.WithReadTimeout(cfg.Timeout),
+			logger.WithMaxRegisters(cfg.MaxRegs),
+			logger.WithZeroAfter(cfg.ZeroAfter),
+			logger.WithPollRate(cfg.PollRate),
+			logger.WithAveragePower(averageWindows...),
+			logger.WithVoltageEventThresholds(cfg.SagThreshold, cfg.SwellThreshold),
+		)
+		opts = append(opts, sinkOpts...)
+		opts = append(opts, preReadWrite...)
+		if cfg.RegType == "input" {
+			opts = append(opts, logger.WithInputRegisters())
+		}
+		if cfg.AlignPolls {
+			opts = append(opts, logger.WithAlignedPolls())
+		}
+		if cfg.Timestamps {
+			opts = append(opts, logger.WithTimestamps())
+		}
+		if cfg.ZeroStart {
+			opts = append(opts, logger.WithEnergyZeroStart())
+		}
+		if cfg.ReadOnScrape > 0 {
+			opts = append(opts, logger.WithReadOnScrape(cfg.ReadOnScrape))
+		}
+		if cfg.ExposeRaw {
+			opts = append(opts, logger.WithRawRegisters())
+		}
+		if cfg.SerialNameReg >= 0 {
+			opts = append(opts, logger.WithSerialNumberName(uint16(cfg.SerialNameReg)))
+		}
+		if cfg.FirmwareReg >= 0 {
+			opts = append(opts, logger.WithFirmwareRegister(uint16(cfg.FirmwareReg)))
+		}
+		if cfg.CTReg >= 0 {
+			opts = append(opts, logger.WithCTRatioRegister(uint16(cfg.CTReg)))
+		}
+		l, err := logger.New(client, d.Name, opts...)
+		if err != nil {
+			return err
+		}
+		// Runs before b.Close so in-flight reads finish before the ports close
+		defer l.Close()
+		loggers = append(loggers, l)
+	}
+
+	if err := registerDeviceMetrics(loggers); err != nil {
+		return err
+	}
+
+	if len(settings) > 0 {
+		return commission(settings, loggers)
+	}
+
+	if cfg.Telegraf {
+		return printLineProtocol(os.Stdout, loggers)
+	}
+
+	idx := &index{}
+	for _, l := range loggers {
+		idx.Devices = append(idx.Devices, l.Name())
+	}
+	idx.handle("/metrics", "Prometheus metrics", promhttp.Handler())
+	idx.handle("/healthz", "Liveness, always ok while serving", healthHandler())
+	idx.handle("/readyz", "Readiness, ok once any device was read in the last few poll intervals", readyHandler(loggers))
+	idx.handle("/snapshot", "Last reading as JSON (optional device)", snapshotHandler(loggers))
+	if cfg.ResetErrors {
+		idx.handle("/reset-errors", "Reset the read error count (POST, optional device)", resetErrorsHandler(loggers))
+	}
+	if cfg.ExposeRaw {
+		idx.handle("/debug/filter", "Energy filter state (optional device)", filterHandler(loggers))
+	}
+	if cfg.SetClock {
+		idx.handle("/set-clock", "Set the device clock (POST, optional device and RFC 3339 time)", setClockHandler(loggers))
+	}
+
+	for _, l := range loggers {
+		var err error
+		if cfg.ReadOnScrape == 0 {
+			err = l.Start()
+		} else if cfg.FailFast {
+			_, err = l.ReadOnce()
+		}
+		if err != nil && cfg.FailFast {
+			return fmt.Errorf("first read of %v failed: %v", l.Name(), err)
+		}
+	}
+	if cfg.TCPKeepalive > 0 {
+		b.startKeepalive(cfg.TCPKeepalive)
+	}
+
+	return serve(ctx, cfg.Listeners, cfg.Web, idx)
+}
+
+// registerDeviceMetrics exports how many devices are configured and how many
+// of them were read successfully on their last poll
+func registerDeviceMetrics(loggers []*logger.Logger) error {
+	configured := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "powerlogger_devices_configured",
+		Help: "Number of devices configured for polling",
+	})
+	configured.Set(float64(len(loggers)))
+	healthy := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "powerlogger_devices_healthy",
+		Help: "Number of devices whose last poll succeeded",
+	}, func() float64 {
+		n := 0
+		for _, l := range loggers {
+			if l.Healthy() {
+				n++
+			}
+		}
+		return float64(n)
+	})
+	for _, c := range []prometheus.Collector{configured, healthy} {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerMap returns the model name and register map of -meterModel,
+// adjusted by the channel flags
+func registerMap(cfg config) (string, logger.RegisterMap, error) {
+	model, registers, err := logger.ModelRegisterMap(cfg.MeterModel)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid -meterModel: %v, expected one of %v", err, strings.Join(logger.Models(), ", "))
+	}
+	switch cfg.TemperatureUnit {
+	case "c":
+	case "f":
+		registers = registers.Fahrenheit()
+	default:
+		return "", nil, fmt.Errorf("unknown temperature unit %q, expected c or f", cfg.TemperatureUnit)
+	}
+	registers, err = registers.Scaled(cfg.Scales)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid -scale: %v", err)
+	}
+	if cfg.Hold.set {
+		registers, err = registers.Holding(cfg.Hold.channels...)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid -holdChannels: %v", err)
+		}
+	}
+	if cfg.Disabled != "" {
+		registers, err = registers.Without(strings.Split(cfg.Disabled, ",")...)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid -disableChannels: %v", err)
+		}
+	}
+	if !cfg.LegacyNames {
+		registers = registers.WithoutLegacyNames()
+	}
+	if cfg.Kilo {
+		registers = registers.Kilo()
+	}
+	return model, registers, nil
+}
+
+// parseRegisterWrite parses a register=value pair, each in decimal or 0x hex
+func parseRegisterWrite(s string) (uint16, uint16, error) {
+	r, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid register write %q, expected register=value", s)
+	}
+	address, err := strconv.ParseUint(r, 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid register %q: %v", r, err)
+	}
+	value, err := strconv.ParseUint(v, 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid register value %q: %v", v, err)
+	}
+	return uint16(address), uint16(value), nil
+}
+
+// resolveDevices returns the devices given with -device, or else the slave
+// IDs given with -slaveIds, the devices found with -discover or the single
+// device given by -dev and -deviceName
+func resolveDevices(cfg config, b *buses) ([]deviceConfig, error) {
+	if len(cfg.Devices) > 0 {
+		return cfg.Devices, nil
+	}
+	if cfg.SlaveIDs != "" && cfg.Discover != "" {
+		return nil, fmt.Errorf("-slaveIds and -discover cannot be combined")
+	}
+	if cfg.SlaveIDs != "" {
+		ids, err := parseSlaveIDs(cfg.SlaveIDs)
+		if err != nil {
+			return nil, err
+		}
+		return busDevices(cfg, ids), nil
+	}
+	if cfg.Discover != "" && cfg.Simulate {
+		return nil, fmt.Errorf("-discover needs a bus and cannot be combined with -simulate")
+	}
+	if cfg.Discover == "" {
+		return []deviceConfig{{Name: cfg.DeviceName, Transport: transportRTU, Address: cfg.Dev, SlaveID: byte(cfg.SlaveID)}}, nil
+	}
+
+	ids, err := parseSlaveIDs(cfg.Discover)
+	if err != nil {
+		return nil, err
+	}
+	probeOpts := []logger.Option{logger.WithMaxRegisters(cfg.MaxRegs)}
+	if cfg.RegType == "input" {
+		probeOpts = append(probeOpts, logger.WithInputRegisters())
+	}
+	found, err := discover(b, cfg.Dev, ids, probeOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no meters found")
+	}
+	return busDevices(cfg, found), nil
+}
+
+// busDevices returns a device for each of the slave IDs on -dev, named
+// <deviceName>-<slave ID>
+func busDevices(cfg config, ids []byte) []deviceConfig {
+	var devices []deviceConfig
+	for _, id := range ids {
+		devices = append(devices, deviceConfig{
+			Name:      fmt.Sprintf("%v-%d", cfg.DeviceName, id),
+			Transport: transportRTU,
+			Address:   cfg.Dev,
+			SlaveID:   id,
+		})
 	}
+	return devices
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scaleFlags collects repeated -scale flags, keyed by channel
+type scaleFlags map[string]float64
+
+func (s scaleFlags) String() string {
+	var pairs []string
+	for channel, scale := range s {
+		pairs = append(pairs, channel+"="+strconv.FormatFloat(scale, 'g', -1, 64))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set parses comma separated channel=scale pairs
+func (s scaleFlags) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		channel, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid scale %q, expected channel=scale", pair)
+		}
+		scale, err := strconv.ParseFloat(v, 64)
+		if err != nil || scale <= 0 {
+			return fmt.Errorf("invalid scale %q for channel %v", v, channel)
+		}
+		s[strings.TrimSpace(channel)] = scale
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "fmt"
+
+// serialConfig holds the line settings of the serial buses
+type serialConfig struct {
+	BaudRate int
+	DataBits int
+	Parity   string
+	StopBits int
+}
+
+func (s serialConfig) validate() error {
+	if s.BaudRate <= 0 {
+		return fmt.Errorf("invalid baud rate: %v", s.BaudRate)
+	}
+	if s.DataBits < 5 || s.DataBits > 8 {
+		return fmt.Errorf("invalid data bits: %v, expected 5 to 8", s.DataBits)
+	}
+	switch s.Parity {
+	case "N", "E", "O":
+	default:
+		return fmt.Errorf("invalid parity %q, expected N, E or O", s.Parity)
+	}
+	if s.StopBits != 1 && s.StopBits != 2 {
+		return fmt.Errorf("invalid stop bits: %v, expected 1 or 2", s.StopBits)
+	}
+	return nil
+}
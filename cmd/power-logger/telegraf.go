@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/diebietse/power-logger/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+// printLineProtocol reads every device once and writes the readings to w as
+// InfluxDB line protocol, for use with the Telegraf exec input
+func printLineProtocol(w io.Writer, loggers []*logger.Logger) error {
+	failed := 0
+	for _, l := range loggers {
+		r, err := l.ReadOnce()
+		if err != nil {
+			log.Errorf("Could not read %v: %v", l.Name(), err)
+			failed++
+			continue
+		}
+		line := r.LineProtocol()
+		if line == "" {
+			log.Warnf("No values read from %v", l.Name())
+			continue
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not read %d of %d devices", failed, len(loggers))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// energySample is the active energy at a point in time
+type energySample struct {
+	t   time.Time
+	kwh float64
+}
+
+// averagePower computes the average active power over rolling windows from
+// the change in active energy, which is smoother than the instantaneous
+// power and matches how demand charges are billed
+type averagePower struct {
+	windows []time.Duration
+	longest time.Duration
+	gauge   *prometheus.GaugeVec
+	samples []energySample // Oldest first
+}
+
+func newAveragePower(label map[string]string, windows []time.Duration) *averagePower {
+	a := &averagePower{
+		windows: windows,
+		gauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "mains_average_power_w",
+			Help:        "Mains average active power over the window",
+			ConstLabels: label,
+		}, []string{"window"}),
+	}
+	for _, w := range windows {
+		a.longest = max(a.longest, w)
+	}
+	return a
+}
+
+// windowLabel formats d without trailing zero units, such as 15m or 1h
+func windowLabel(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// update adds a sample and sets the average of every window that is
+// covered by the samples so far
+func (a *averagePower) update(t time.Time, kwh float64) {
+	// Keep the newest sample at or before the longest window as its start
+	from := t.Add(-a.longest)
+	i := 0
+	for i+1 < len(a.samples) && !a.samples[i+1].t.After(from) {
+		i++
+	}
+	a.samples = append(a.samples[i:], energySample{t: t, kwh: kwh})
+
+	for _, w := range a.windows {
+		start, ok := a.sampleBefore(t.Add(-w))
+		if !ok {
+			continue
+		}
+		hours := t.Sub(start.t).Hours()
+		if hours <= 0 {
+			continue
+		}
+		a.gauge.WithLabelValues(windowLabel(w)).Set((kwh - start.kwh) / hours * 1000)
+	}
+}
+
+// sampleBefore returns the newest sample at or before t
+func (a *averagePower) sampleBefore(t time.Time) (energySample, bool) {
+	for i := len(a.samples) - 1; i >= 0; i-- {
+		if !a.samples[i].t.After(t) {
+			return a.samples[i], true
+		}
+	}
+	return energySample{}, false
+}
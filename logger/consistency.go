@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pfConsistencyTolerance = 0.1 // Largest plausible difference between the reported and computed power factor
+	minConsistencyPower    = 50  // Apparent power in VA below which the rounding of the powers dominates
+)
+
+// powerFactorCheck computes the power factor from the active and apparent
+// power and counts the polls it disagrees with the reported power factor,
+// such as when the apparent power register is stuck
+type powerFactorCheck struct {
+	computed prometheus.Gauge
+	warnings prometheus.Counter
+}
+
+func newPowerFactorCheck(label map[string]string) *powerFactorCheck {
+	computed := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "mains_power_factor_computed_pf",
+		Help:        "Mains power factor computed from the active and apparent power",
+		ConstLabels: label,
+	})
+	computed.Set(math.NaN())
+	return &powerFactorCheck{
+		computed: computed,
+		warnings: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "sensor_consistency_warnings_count",
+			Help:        "Polls whose reported power factor disagrees with the one computed from the active and apparent power",
+			ConstLabels: label,
+		}),
+	}
+}
+
+// update checks the power channels of a reading. The power factors are
+// compared without their signs, which meters use for either the direction of
+// the power or the phase of the current.
+func (c *powerFactorCheck) update(name string, values map[string]float64) {
+	active, activeOK := values["active_power"]
+	apparent, apparentOK := values["apparent_power"]
+	if !activeOK || !apparentOK || apparent == 0 {
+		c.computed.Set(math.NaN())
+		return
+	}
+	computed := active / apparent
+	c.computed.Set(computed)
+
+	pf, ok := values["power_factor"]
+	if !ok || apparent < minConsistencyPower {
+		return
+	}
+	if diff := math.Abs(math.Abs(pf) - math.Abs(computed)); diff > pfConsistencyTolerance {
+		log.Warnf("%v power factor %v disagrees with %.3f computed from %vW and %vVA", name, pf, computed, active, apparent)
+		c.warnings.Inc()
+	}
+}
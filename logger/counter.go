@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterGauge is a gauge exported as a counter. The energy registers are
+// cumulative, but the read values have to be set rather than added, which
+// prometheus.Counter does not allow.
+type counterGauge struct {
+	prometheus.Gauge
+}
+
+func (c counterGauge) Write(out *dto.Metric) error {
+	if err := c.Gauge.Write(out); err != nil {
+		return err
+	}
+	value := out.GetGauge().GetValue()
+	out.Gauge = nil
+	out.Counter = &dto.Counter{Value: &value}
+	return nil
+}
+
+func (c counterGauge) Collect(ch chan<- prometheus.Metric) {
+	ch <- c
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CSV is a Sink appending every reading to a file as a row of comma
+// separated values, starting every file with a header row
+type CSV struct {
+	mu       sync.Mutex
+	file     *rotatingFile
+	channels []string
+}
+
+// NewCSV returns a sink appending the values of channels to the file at path,
+// after the read time and the device name. Channels a reading has no value
+// for are left empty. The file is rotated once it exceeds maxSize bytes or
+// maxAge, if they are not zero.
+func NewCSV(path string, channels []string, maxSize int64, maxAge time.Duration) (*CSV, error) {
+	c := &CSV{channels: channels}
+	c.file = &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, header: c.header}
+	if err := c.file.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CSV) header(w io.Writer) error {
+	return c.writeRow(w, append([]string{"time", "device"}, c.channels...))
+}
+
+// writeRow writes the row with a single write, so a failing write does not
+// leave a partial row behind
+func (c *CSV) writeRow(w io.Writer, row []string) error {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+	cw.Flush()
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Write appends the reading as a row
+func (c *CSV) Write(r Reading) error {
+	row := make([]string, 0, 2+len(c.channels))
+	row = append(row, r.Time.Format(time.RFC3339Nano), r.Device)
+	for _, channel := range c.channels {
+		v, ok := r.Values[channel]
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeRow(c.file, row)
+}
+
+// Close closes the file
+func (c *CSV) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"github.com/goburrow/modbus"
+)
+
+const (
+	// classDeviceGone the serial device or its driver is no longer present
+	classDeviceGone = "device_gone"
+	// classRead the read failed, typically a meter timeout or exception
+	classRead = "read"
+	// classInvalidLength the read returned no data at all
+	classInvalidLength = "invalid_length"
+	// classLengthMismatch the read returned data of the wrong length, which
+	// points at framing problems on the bus or gateway
+	classLengthMismatch = "length_mismatch"
+)
+
+var errorClasses = []string{classDeviceGone, classRead, classInvalidLength, classLengthMismatch}
+
+// lengthError is returned for reads with an unexpected number of bytes
+type lengthError struct {
+	expected int
+	actual   int
+}
+
+func (e *lengthError) Error() string {
+	return fmt.Sprintf("invalid read size: %v, expected %v", e.actual, e.expected)
+}
+
+// span is a range of byte offsets into a read block
+type span struct {
+	start int
+	end   int
+}
+
+func isMissing(missing []span, offset int) bool {
+	for _, s := range missing {
+		if offset >= s.start && offset < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// isIllegalAddress reports whether the meter does not implement the
+// registers that were read
+func isIllegalAddress(err error) bool {
+	var modbusErr *modbus.ModbusError
+	return errors.As(err, &modbusErr) && modbusErr.ExceptionCode == modbus.ExceptionCodeIllegalDataAddress
+}
+
+// isDeviceGone reports whether err comes from the serial device itself,
+// such as a USB adapter being unplugged, rather than from the meter
+func isDeviceGone(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.ENXIO) ||
+		errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.EBADF)
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const rotateTimeFormat = "20060102T150405.000"
+
+// rotatingFile appends to the file at path, moving it aside to
+// <path>.<time> once it grows beyond maxSize bytes or gets older than maxAge.
+// A zero maxSize or maxAge disables that limit.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	// header is written at the start of every new file, if set
+	header func(w io.Writer) error
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	if f.size == 0 && f.header != nil {
+		return f.header(f)
+	}
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	rotated := fmt.Sprintf("%v.%v", f.path, time.Now().Format(rotateTimeFormat))
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Write appends p, rotating the file first if it is due
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	due := (f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize) ||
+		(f.maxAge > 0 && time.Since(f.opened) > f.maxAge)
+	if due {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	grafanaQueueSize = 64
+	grafanaTimeout   = 5 * time.Second
+)
+
+// GrafanaLive is a Sink pushing readings to a Grafana Live stream as
+// InfluxDB line protocol. Readings are pushed in the background and dropped
+// if Grafana falls behind, so polling is never held up.
+type GrafanaLive struct {
+	url    string
+	token  string
+	client *http.Client
+	queue  chan Reading
+	done   chan struct{}
+}
+
+// NewGrafanaLive returns a sink pushing to the stream streamID of the Grafana
+// server at grafanaURL, authenticating with the API token
+func NewGrafanaLive(grafanaURL, token, streamID string) *GrafanaLive {
+	g := &GrafanaLive{
+		url:    strings.TrimSuffix(grafanaURL, "/") + "/api/live/push/" + url.PathEscape(streamID),
+		token:  token,
+		client: &http.Client{Timeout: grafanaTimeout},
+		queue:  make(chan Reading, grafanaQueueSize),
+		done:   make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+// Write queues the reading to be pushed
+func (g *GrafanaLive) Write(r Reading) error {
+	select {
+	case g.queue <- r:
+		return nil
+	default:
+		return fmt.Errorf("grafana live queue full, dropping reading of %v", r.Device)
+	}
+}
+
+// Close pushes the queued readings and stops the sink
+func (g *GrafanaLive) Close() {
+	close(g.queue)
+	<-g.done
+}
+
+func (g *GrafanaLive) run() {
+	defer close(g.done)
+	for r := range g.queue {
+		if err := g.push(r); err != nil {
+			log.Errorf("Could not push to grafana live: %v", err)
+		}
+	}
+}
+
+func (g *GrafanaLive) push(r Reading) error {
+	line := r.LineProtocol()
+	if line == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, g.url, strings.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// JSONLines is a Sink appending every reading to a file as one JSON object
+// per line
+type JSONLines struct {
+	mu   sync.Mutex
+	file *rotatingFile
+}
+
+// NewJSONLines returns a sink appending to the file at path, rotating it
+// once it exceeds maxSize bytes or maxAge, if they are not zero
+func NewJSONLines(path string, maxSize int64, maxAge time.Duration) (*JSONLines, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return &JSONLines{file: f}, nil
+}
+
+// Write appends the reading as a line of JSON
+func (j *JSONLines) Write(r Reading) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (j *JSONLines) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
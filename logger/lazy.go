@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lazyCollector reads the meter when it is scraped rather than from a poll
+// loop. Scrapes within maxAge of the last read share it, so concurrent
+// scrapes cause a single bus transaction. Every scrape waits for the read,
+// so scrape latency includes a full Modbus transaction.
+type lazyCollector struct {
+	l      *Logger
+	inner  prometheus.Collector
+	maxAge time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (c *lazyCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.inner.Describe(ch)
+}
+
+func (c *lazyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.refresh()
+	c.inner.Collect(ch)
+}
+
+func (c *lazyCollector) refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.last) < c.maxAge {
+		return
+	}
+	_ = c.l.poll()
+	c.last = time.Now()
+}
+
+// gaugeCollector collects the measurement gauges as they are
+type gaugeCollector struct {
+	l *Logger
+}
+
+func (c gaugeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range c.l.gauges {
+		g.describe(ch)
+	}
+}
+
+func (c gaugeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, g := range c.l.gauges {
+		if !g.exported() {
+			continue
+		}
+		for _, m := range g.metrics() {
+			ch <- m
+		}
+	}
+}
@@ -1,9 +1,16 @@
 package logger
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/goburrow/modbus"
@@ -26,9 +33,11 @@ const (
 	ApparentPowerReg = 10
 	// PowerFactorReg input power factor register of 16 bits
 	PowerFactorReg = 12
-	// ActiveEnergyReg input active energy register of 5 x 32 bits
+	// ActiveEnergyReg input active energy register of 5 x 32 bits, the total
+	// followed by the tariff bins
 	ActiveEnergyReg = 14
-	// ReactiveEnergyReg input reactive energy register of 5 x 32 bits
+	// ReactiveEnergyReg input reactive energy register of 5 x 32 bits, the
+	// total followed by the tariff bins
 	ReactiveEnergyReg = 34
 	// TsReg energy time slot registers of 4 x 24 bits
 	TsReg = 54
@@ -38,196 +47,591 @@ const (
 	TemperatureReg = 74
 )
 
+// Model is the meter model whose register layout this package decodes
+const Model = "YTL-e D113003"
+
 const (
 	readSize        = 39
+	clockSize       = 4
+	tariffs         = 4 // Energy bins following each energy total
+	serialSize      = 2
+	maxReadRegs     = 125 // The most registers a single Modbus read can return
+	chunkRetries    = 2
+	retryJitter     = 50 * time.Millisecond // Default upper bound of the random delay before a retry
+	readTimeout     = 5 * time.Second       // Default handler timeout
 	pollRateSec     = 10
 	avgVoltage      = 230
-	meterMaxCurrent = 100 // The power meter is rated for 100A
+	voltageBand     = 0.15 // Allowed deviation from the nominal voltage
+	meterMaxCurrent = 100  // The power meter is rated for 100A
+	watchdogFactor  = 3    // Poll intervals without a tick before the poller is restarted
+	readyPolls      = 3    // Poll intervals a successful read keeps the logger ready
+	pfTolerance     = 0.05 // Power factors this far above 1 are taken as a wrong scale
 )
 
-// Logger contains the Gauges for a logger instance
+const (
+	// modeFull reads the whole register block at once
+	modeFull = "full"
+	// modeMinimal reads only the core measurements, see coreBlocks
+	modeMinimal = "minimal"
+)
+
+// readDurationBuckets are the sensor_read_duration_seconds buckets as
+// fractions of the read timeout
+var readDurationBuckets = []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1}
+
+// durationBuckets returns the read duration buckets up to timeout
+func durationBuckets(timeout time.Duration) []float64 {
+	buckets := make([]float64, len(readDurationBuckets))
+	for i, f := range readDurationBuckets {
+		buckets[i] = f * timeout.Seconds()
+	}
+	return buckets
+}
+
+// coreBlocks are the registers read in minimal mode, leaving out the time
+// slot and clock registers that sparse meters do not implement
+var coreBlocks = []struct{ address, quantity uint16 }{
+	{0, TsReg / 2},
+	{TemperatureReg / 2, 1},
+}
+
+var errClosed = errors.New("logger closed")
+
+// Logger contains the Gauges for a logger instance. Every Logger polls from
+// its own goroutine, so a hung device only stalls its own Logger. Loggers
+// sharing one serial bus are still serialized by the bus itself, so a slow
+// device there delays the others by up to the handler timeout.
 type Logger struct {
-	client       modbus.Client
-	gauges       []loggerGauge
-	readFailures prometheus.Gauge
-	wg           sync.WaitGroup
-	stop         chan struct{}
+	client          modbus.Client
+	name            string
+	serialNumberReg *uint16
+	firmwareReg     *uint16
+	ctRatioReg      *uint16
+	registers       RegisterMap
+	blockSize       uint16
+	startupProbe    bool
+	inputRegisters  bool
+	preReadWrite    *registerWrite
+	serial          string
+	firmware        string
+	ctRatio         string
+	info            MeterInfo
+	meterInfo       prometheus.Gauge
+	scaleInfo       *prometheus.GaugeVec
+	rangeViolations *prometheus.CounterVec
+	filterActive    *prometheus.GaugeVec
+	gauges          []loggerGauge
+	readFailures    prometheus.Gauge
+	errorClass      *prometheus.GaugeVec
+	minimal         atomic.Bool
+	readMode        *prometheus.GaugeVec
+	lastPoll        prometheus.Gauge
+	readDuration    prometheus.Histogram
+	stale           prometheus.Gauge
+	healthy         atomic.Bool
+	lastSuccess     atomic.Int64
+	zeroAfter       int
+	failures        atomic.Int64 // Consecutive failed reads
+	pfWarned        atomic.Bool
+	exposeRaw       bool
+	rawRegisters    *prometheus.GaugeVec
+	sinks           []Sink
+	timestamps      bool
+	lazyMaxAge      time.Duration
+	lazy            *lazyCollector
+	sampleTime      atomic.Int64
+	lastTick        atomic.Int64
+	pollRate        time.Duration
+	alignTicks      bool
+	maxRegs         int
+	retryJitter     time.Duration
+	readTimeout     time.Duration
+	reconnect       *Reconnector
+	nominalVoltage  float64
+	filterWindow    int
+	filterCurrent   float64
+	zeroStart       bool
+	sagThreshold    float64
+	swellThreshold  float64
+	voltageEvents   *voltageEvents
+	averageWindows  []time.Duration
+	averagePower    *averagePower
+	pfCheck         *powerFactorCheck
+	netEnergy       *netEnergy
+	updateMu        sync.Mutex // Serialises update, as a restarted poll loop may run next to a stuck one
+	readingMu       sync.Mutex
+	reading         Reading
+	busMu           sync.Mutex
+	closed          bool
+	wg              sync.WaitGroup
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+// registerWrite is a value to write to a single holding register
+type registerWrite struct {
+	address uint16
+	value   uint16
 }
 
 type loggerGauge struct {
 	prometheus.Gauge
+	channel   string
 	register  int
 	scale     float64
-	valueFunc func(data []byte, offset int, scale float64) float64
-	filter    func(value float64, t time.Time) float64
+	valueFunc ValueFunc
+	filter    *energyFilter
 	sticky    bool
+	min       float64 // Values outside of min and max are discarded, if set
+	max       float64
+	omitted   *atomic.Bool     // Set while the gauge is left out of scrapes, nil if it never is
+	legacy    *prometheus.Desc // Also exported under this legacy metric name, if set
 }
 
-// New returns new logger with a given name and modbus client
-func New(client modbus.Client, deviceName string) (*Logger, error) {
-	label := map[string]string{"device_name": deviceName}
+// exported reports whether the gauge is collected
+func (g loggerGauge) exported() bool {
+	return g.omitted == nil || !g.omitted.Load()
+}
+
+// describe sends the descriptions of the gauge and its legacy name
+func (g loggerGauge) describe(ch chan<- *prometheus.Desc) {
+	g.Describe(ch)
+	if g.legacy != nil {
+		ch <- g.legacy
+	}
+}
 
+// metrics returns the gauge, followed by its value under the legacy name
+func (g loggerGauge) metrics() []prometheus.Metric {
+	if g.legacy == nil {
+		return []prometheus.Metric{g}
+	}
+	return []prometheus.Metric{g, legacyMetric{Metric: g, desc: g.legacy}}
+}
+
+// legacyMetric is a metric exported under a different name
+type legacyMetric struct {
+	prometheus.Metric
+	desc *prometheus.Desc
+}
+
+func (m legacyMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+// New returns new logger with a given name and modbus client
+func New(client modbus.Client, deviceName string, opts ...Option) (*Logger, error) {
 	l := &Logger{
-		client: client,
-		gauges: generateGauges(label),
-		readFailures: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "sensor_read_errors_count",
-			Help:        "Sensor read errors",
-			ConstLabels: label,
-		}),
-		wg:   sync.WaitGroup{},
-		stop: make(chan struct{}),
+		client:         client,
+		name:           deviceName,
+		pollRate:       time.Second * pollRateSec,
+		maxRegs:        maxReadRegs,
+		retryJitter:    retryJitter,
+		readTimeout:    readTimeout,
+		registers:      DefaultRegisterMap(),
+		nominalVoltage: avgVoltage,
+		filterCurrent:  meterMaxCurrent,
+		zeroAfter:      1,
+		sagThreshold:   defaultSagThreshold,
+		swellThreshold: defaultSwellThreshold,
+		wg:             sync.WaitGroup{},
+		stop:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.info.Model == "" {
+		l.info.Model = Model
+	}
+	if err := l.registers.validate(); err != nil {
+		return nil, fmt.Errorf("invalid register map: %v", err)
+	}
+	if l.readTimeout <= 0 {
+		return nil, fmt.Errorf("invalid read timeout: %v", l.readTimeout)
+	}
+	if l.maxRegs < 1 || l.maxRegs > maxReadRegs {
+		return nil, fmt.Errorf("invalid registers per read: %v, must be 1 to %v", l.maxRegs, maxReadRegs)
+	}
+	l.blockSize = l.registers.blockSize()
+	if l.serialNumberReg != nil {
+		serial, err := readSerialNumber(client, *l.serialNumberReg)
+		if err != nil {
+			log.Warnf("Could not read serial number, using %v as device name: %v", deviceName, err)
+		} else {
+			l.name = serial
+			l.serial = serial
+		}
+	}
+	if l.firmwareReg != nil {
+		l.firmware = readInfoRegister(client, l.name, "firmware version", *l.firmwareReg)
+	}
+	if l.ctRatioReg != nil {
+		l.ctRatio = readInfoRegister(client, l.name, "CT ratio", *l.ctRatioReg)
+	}
+	if l.startupProbe {
+		if _, _, err := l.readBlock(0, l.blockSize); err != nil {
+			return nil, fmt.Errorf("startup probe of %v failed, check the serial settings and meter model: %v", l.name, err)
+		}
 	}
 
+	label := map[string]string{"device_name": l.name}
+	l.readFailures = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "sensor_read_errors_count",
+		Help:        "Sensor read errors",
+		ConstLabels: label,
+	})
+	l.errorClass = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "sensor_read_error_class_count",
+		Help:        "Sensor read errors by class",
+		ConstLabels: label,
+	}, []string{"class"})
+	l.readMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "sensor_read_mode",
+		Help:        "Set to 1 for the active read mode, full or minimal",
+		ConstLabels: label,
+	}, []string{"mode"})
+	l.readMode.WithLabelValues(modeFull).Set(1)
+	l.readMode.WithLabelValues(modeMinimal).Set(0)
+	l.stale = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "sensor_data_stale",
+		Help:        "Set to 1 while the served values are not from a successful latest poll",
+		ConstLabels: label,
+	})
+	l.stale.Set(1)
+	l.readDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        "sensor_read_duration_seconds",
+		Help:        "Duration of sensor reads, including retries and failed reads",
+		ConstLabels: label,
+		Buckets:     durationBuckets(l.readTimeout),
+	})
+	l.lastPoll = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "sensor_last_poll_timestamp_seconds",
+		Help:        "Time of the last sensor poll",
+		ConstLabels: label,
+	})
+	l.meterInfo = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mains_meter_info",
+		Help: "Static meter attributes",
+		ConstLabels: map[string]string{
+			"device_name": l.name,
+			"model":       l.info.Model,
+			"serial":      l.serial,
+			"firmware":    l.firmware,
+			"ct_ratio":    l.ctRatio,
+			"slave_id":    l.info.SlaveID,
+			"transport":   l.info.Transport,
+		},
+	})
+	l.meterInfo.Set(1)
+
+	if l.nominalVoltage <= 0 {
+		return nil, fmt.Errorf("invalid nominal voltage: %v", l.nominalVoltage)
+	}
+	if l.sagThreshold <= 0 || l.sagThreshold >= 1 || l.swellThreshold <= 1 {
+		return nil, fmt.Errorf("invalid voltage sag/swell thresholds: %v/%v", l.sagThreshold, l.swellThreshold)
+	}
+	if l.pollRate < time.Second {
+		return nil, fmt.Errorf("invalid poll rate: %v, must be at least 1s", l.pollRate)
+	}
+	if l.retryJitter < 0 {
+		return nil, fmt.Errorf("invalid retry jitter: %v", l.retryJitter)
+	}
+	for _, w := range l.averageWindows {
+		if w <= 0 {
+			return nil, fmt.Errorf("invalid average power window: %v", w)
+		}
+	}
+	if l.zeroAfter < 1 {
+		return nil, fmt.Errorf("invalid number of failures before zeroing: %v", l.zeroAfter)
+	}
+	if l.filterCurrent <= 0 {
+		return nil, fmt.Errorf("invalid energy filter max current: %v", l.filterCurrent)
+	}
+	if l.filterWindow < 0 {
+		return nil, fmt.Errorf("invalid energy filter window: %v", l.filterWindow)
+	}
+	l.gauges = generateGauges(l.registers, label, l.nominalVoltage, l.filterCurrent, l.filterWindow, l.zeroStart)
+	l.rangeViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "sensor_range_violations_count",
+		Help:        "Readings discarded for being outside of the plausible range of their channel",
+		ConstLabels: label,
+	}, []string{"channel"})
 	for _, g := range l.gauges {
-		if err := prometheus.Register(g); err != nil {
-			return nil, fmt.Errorf("could not register gauge: %v", err)
+		if g.max > g.min {
+			l.rangeViolations.WithLabelValues(g.channel)
 		}
 	}
+	l.scaleInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mains_scale_info",
+		Help:        "Scale the raw value of each channel is divided by",
+		ConstLabels: label,
+	}, []string{"channel", "scale"})
+	l.filterActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "energy_filter_active",
+		Help:        "Set to 1 while the energy filter discards the readings of the channel",
+		ConstLabels: label,
+	}, []string{"channel"})
+	for _, g := range l.gauges {
+		l.scaleInfo.WithLabelValues(g.channel, strconv.FormatFloat(g.scale, 'g', -1, 64)).Set(1)
+		if g.filter != nil {
+			l.filterActive.WithLabelValues(g.channel).Set(0)
+		}
+	}
+	l.voltageEvents = newVoltageEvents(label, l.nominalVoltage*l.sagThreshold, l.nominalVoltage*l.swellThreshold)
+	log.Printf("Accepting %v voltages from %.1fV to %.1fV", l.name,
+		l.nominalVoltage*(1-voltageBand), l.nominalVoltage*(1+voltageBand))
 
-	if err := prometheus.Register(l.readFailures); err != nil {
-		return nil, fmt.Errorf("could not register gauge: %v", err)
+	var gauges prometheus.Collector = gaugeCollector{l: l}
+	if l.timestamps {
+		gauges = timestampCollector{l: l}
+	}
+	if l.lazyMaxAge > 0 {
+		l.lazy = &lazyCollector{l: l, inner: gauges, maxAge: l.lazyMaxAge}
+		gauges = l.lazy
+	}
+	if err := prometheus.Register(gauges); err != nil {
+		return nil, fmt.Errorf("could not register gauges: %v", err)
+	}
+
+	for _, c := range errorClasses {
+		l.errorClass.WithLabelValues(c).Set(0)
+	}
+	collectors := []prometheus.Collector{
+		l.readFailures,
+		l.errorClass,
+		l.readMode,
+		l.lastPoll,
+		l.readDuration,
+		l.stale,
+		l.meterInfo,
+		l.scaleInfo,
+		l.filterActive,
+		l.rangeViolations,
+		l.voltageEvents.sags,
+		l.voltageEvents.swells,
+	}
+	if l.hasChannels("active_power", "apparent_power") {
+		l.pfCheck = newPowerFactorCheck(label)
+		collectors = append(collectors, l.pfCheck.computed, l.pfCheck.warnings)
+	}
+	if l.hasChannels(ImportEnergyChannel, ExportEnergyChannel) {
+		sticky := true
+		for _, g := range l.gauges {
+			if g.channel == ImportEnergyChannel || g.channel == ExportEnergyChannel {
+				sticky = sticky && g.sticky
+			}
+		}
+		l.netEnergy = newNetEnergy(label, sticky)
+		collectors = append(collectors, l.netEnergy.gauge)
+	}
+	if len(l.averageWindows) > 0 {
+		l.averagePower = newAveragePower(label, l.averageWindows)
+		collectors = append(collectors, l.averagePower.gauge)
+	}
+	if l.exposeRaw {
+		l.rawRegisters = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "mains_raw_register",
+			Help:        "Raw 16 bit register value by byte offset in the read block",
+			ConstLabels: label,
+		}, []string{"offset"})
+		collectors = append(collectors, l.rawRegisters)
+	}
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			return nil, fmt.Errorf("could not register metric: %v", err)
+		}
 	}
 
 	return l, nil
 }
 
-func generateGauges(label map[string]string) []loggerGauge {
-	return []loggerGauge{
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_voltage_v",
-				Help:        "Mains voltage",
-				ConstLabels: label,
-			}),
-			register:  VoltageReg,
-			scale:     10,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_current_a",
-				Help:        "Mains current",
-				ConstLabels: label,
-			}),
-			register:  CurrentReg,
-			scale:     10,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_frequency_hz",
-				Help:        "Mains frequency",
-				ConstLabels: label,
-			}),
-			register:  FrequencyReg,
-			scale:     10,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_active_power_w",
-				Help:        "Mains active power",
-				ConstLabels: label,
-			}),
-			register:  ActivePowerReg,
-			scale:     1,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_reactive_power_var",
-				Help:        "Mains reactive power",
-				ConstLabels: label,
-			}),
-			register:  ReactivePowerReg,
-			scale:     1,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_appartent_power_va",
-				Help:        "Mains appartent power",
-				ConstLabels: label,
-			}),
-			register:  ApparentPowerReg,
-			scale:     1,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_power_factor_pf",
-				Help:        "Mains power factor",
-				ConstLabels: label,
-			}),
-			register:  PowerFactorReg,
-			scale:     1000,
-			valueFunc: get16BitValue,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_active_energy_kwh",
-				Help:        "Mains active energy",
-				ConstLabels: label,
-			}),
-			register:  ActiveEnergyReg,
-			scale:     100,
-			valueFunc: get32BitEnergy,
-			filter:    newEnergyFilter(meterMaxCurrent).filter,
-			sticky:    true,
-		},
-		{
-			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_reactive_energy_kvarh",
-				Help:        "Mains reactive energy",
-				ConstLabels: label,
-			}),
-			register:  ReactiveEnergyReg,
-			scale:     100,
-			valueFunc: get32BitEnergy,
-			filter:    newEnergyFilter(meterMaxCurrent).filter,
-			sticky:    true,
-		},
-		{
+// Probe reads the measurement registers through client and returns the
+// meter model if the response matches the layout decoded by this package.
+// Options such as WithInputRegisters and WithMaxRegisters select how the
+// registers are read, the same as for New.
+func Probe(client modbus.Client, opts ...Option) (string, error) {
+	l := &Logger{client: client, name: "probe", maxRegs: maxReadRegs, retryJitter: retryJitter}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.maxRegs < 1 || l.maxRegs > maxReadRegs {
+		return "", fmt.Errorf("invalid registers per read: %v, must be 1 to %v", l.maxRegs, maxReadRegs)
+	}
+	res, _, err := l.readBlock(0, readSize)
+	if err != nil {
+		return "", fmt.Errorf("could not read values: %v", err)
+	}
+	if f := get16BitValue(res, FrequencyReg, 10); f < 45 || f > 65 {
+		return "", fmt.Errorf("implausible mains frequency: %v", f)
+	}
+	return Model, nil
+}
+
+func readSerialNumber(client modbus.Client, address uint16) (string, error) {
+	res, err := client.ReadHoldingRegisters(address, serialSize)
+	if err != nil {
+		return "", err
+	}
+	if len(res) != serialSize*2 {
+		return "", fmt.Errorf("invalid read size: %v", len(res))
+	}
+	serial := binary.BigEndian.Uint32(res)
+	if serial == 0 {
+		return "", errors.New("serial number not set")
+	}
+	return strconv.FormatUint(uint64(serial), 10), nil
+}
+
+// readInfoRegister reads the holding register at address for a label of
+// mains_meter_info, leaving the label empty if it cannot be read
+func readInfoRegister(client modbus.Client, name, what string, address uint16) string {
+	res, err := client.ReadHoldingRegisters(address, 1)
+	if err == nil && len(res) != 2 {
+		err = fmt.Errorf("invalid read size: %v", len(res))
+	}
+	if err != nil {
+		log.Warnf("Could not read the %v of %v: %v", what, name, err)
+		return ""
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(res)))
+}
+
+// Name returns the device name of the logger
+func (l *Logger) Name() string {
+	return l.name
+}
+
+func generateGauges(registers RegisterMap, label map[string]string, nominalVoltage, filterCurrent float64, filterWindow int, zeroStart bool) []loggerGauge {
+	gauges := make([]loggerGauge, 0, len(registers))
+	for _, m := range registers {
+		labels := maps.Clone(label)
+		maps.Copy(labels, m.Labels)
+		g := loggerGauge{
 			Gauge: prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        "mains_device_temperature_c",
-				Help:        "Mains device temperature",
-				ConstLabels: label,
+				Name:        m.metricName(),
+				Help:        m.Help,
+				ConstLabels: labels,
 			}),
-			register:  TemperatureReg,
-			scale:     1,
-			valueFunc: get16BitValue,
-		},
+			channel:   m.Channel,
+			register:  m.Offset,
+			scale:     m.Scale,
+			valueFunc: m.Value,
+			min:       m.Min,
+			max:       m.Max,
+		}
+		if name := m.legacyMetricName(); name != "" {
+			g.legacy = prometheus.NewDesc(name, m.Help+", deprecated name of "+m.metricName(), nil, labels)
+		}
+		if m.Channel == "voltage" && m.Max <= m.Min {
+			g.min = nominalVoltage * (1 - voltageBand)
+			g.max = nominalVoltage * (1 + voltageBand)
+		}
+		g.sticky = m.Hold
+		if m.OmitZero {
+			g.omitted = &atomic.Bool{}
+			g.omitted.Store(true)
+		}
+		if m.Cumulative {
+			maxCurrent := m.MaxCurrent
+			if maxCurrent == 0 {
+				maxCurrent = filterCurrent
+			}
+			g.filter = newEnergyFilter(maxCurrent, nominalVoltage, filterWindow, zeroStart)
+			// The energy filter keeps out decreases, so the value only resets
+			// when it is zeroed on read errors without Hold
+			g.Gauge = counterGauge{Gauge: g.Gauge}
+		}
+		gauges = append(gauges, g)
 	}
+	return gauges
 }
 
-func newEnergyFilter(maxCurrent float64) *energyFilter {
+// newEnergyFilter returns a filter discarding energy readings that decrease
+// or increase faster than maxCurrent at voltage allows. After window
+// consecutive discarded readings the next one is accepted regardless, such as
+// after a meter swap, 0 to never do so. The first non-zero reading is taken
+// as the baseline, or also a zero reading if zeroStart is set.
+func newEnergyFilter(maxCurrent, voltage float64, window int, zeroStart bool) *energyFilter {
 	// Maximum kWh increase per second
-	max := (((maxCurrent * avgVoltage) / 1000) / time.Hour.Seconds())
+	max := (((maxCurrent * voltage) / 1000) / time.Hour.Seconds())
 	return &energyFilter{
 		maxIncrease: max,
+		window:      window,
+		zeroStart:   zeroStart,
 	}
 }
 
+const (
+	rejectDecrease = "decrease"
+	rejectIncrease = "increase too fast"
+)
+
 type energyFilter struct {
-	prevChange  time.Time
-	prevValid   float64
-	maxIncrease float64
+	mu           sync.Mutex
+	prevChange   time.Time
+	prevValid    float64
+	hasBaseline  bool
+	maxIncrease  float64
+	window       int
+	rejected     int // Consecutive rejected readings consistent with each other
+	zeroStart    bool
+	lastRejected float64
+	rejectedAt   time.Time
+	reason       string
+}
+
+// FilterState is the state of the energy filter of a channel
+type FilterState struct {
+	Channel      string    `json:"channel"`
+	LastAccepted float64   `json:"last_accepted"`
+	LastChange   time.Time `json:"last_change"`
+	LastRejected float64   `json:"last_rejected"`
+	Reason       string    `json:"reason"`     // Why LastRejected was discarded, empty if nothing was
+	Rejections   int       `json:"rejections"` // Consecutive discarded readings that are consistent with each other
+}
+
+func (f *energyFilter) state() FilterState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FilterState{
+		LastAccepted: f.prevValid,
+		LastChange:   f.prevChange,
+		LastRejected: f.lastRejected,
+		Reason:       f.reason,
+		Rejections:   f.rejected,
+	}
 }
 
 func (f *energyFilter) filter(in float64, t time.Time) float64 {
-	if f.prevValid == 0 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.hasBaseline {
 		f.prevChange = t
 		f.prevValid = in
+		f.hasBaseline = in != 0 || f.zeroStart
 		return in
 	}
-	if in < f.prevValid {
-		return f.prevValid
-	}
 	maxIncrease := f.maxIncrease * t.Sub(f.prevChange).Seconds()
-	if in > f.prevValid+maxIncrease {
-		return f.prevValid
+	if in < f.prevValid || in > f.prevValid+maxIncrease {
+		// Only a jump that persists counts towards the window, not a run of
+		// unrelated garbage readings
+		maxPersisted := f.lastRejected + f.maxIncrease*t.Sub(f.rejectedAt).Seconds()
+		if f.rejected > 0 && in >= f.lastRejected && in <= maxPersisted {
+			f.rejected++
+		} else {
+			f.rejected = 1
+		}
+		f.lastRejected = in
+		f.rejectedAt = t
+		f.reason = rejectIncrease
+		if in < f.prevValid {
+			f.reason = rejectDecrease
+		}
+		if f.window == 0 || f.rejected <= f.window {
+			return f.prevValid
+		}
 	}
+	f.rejected = 0
 	if f.prevValid != in {
 		f.prevChange = t
 	}
@@ -235,71 +639,595 @@ func (f *energyFilter) filter(in float64, t time.Time) float64 {
 	return in
 }
 
+// readRegisters serialises reads with any other transaction on the client,
+// reading input registers instead of holding registers if input is set
+func (l *Logger) readRegisters(address, quantity uint16, input bool) ([]byte, error) {
+	l.busMu.Lock()
+	defer l.busMu.Unlock()
+	if l.closed {
+		return nil, errClosed
+	}
+	read := l.client.ReadHoldingRegisters
+	if input {
+		read = l.client.ReadInputRegisters
+	}
+	start := time.Now()
+	res, err := read(address, quantity)
+	log.Debugf("Read %d registers at %d from %v in %v", quantity, address, l.name, time.Since(start))
+	return res, err
+}
+
+func (l *Logger) writeRegisters(address, quantity uint16, data []byte) error {
+	l.busMu.Lock()
+	defer l.busMu.Unlock()
+	if l.closed {
+		return errClosed
+	}
+	_, err := l.client.WriteMultipleRegisters(address, quantity, data)
+	return err
+}
+
+// writeRegister serialises a single register write with any other
+// transaction on the client
+func (l *Logger) writeRegister(address, value uint16) error {
+	l.busMu.Lock()
+	defer l.busMu.Unlock()
+	if l.closed {
+		return errClosed
+	}
+	_, err := l.client.WriteSingleRegister(address, value)
+	return err
+}
+
+// readBlock reads quantity registers from address in chunks of at most
+// maxRegs registers. Chunks the meter reports as illegal addresses are
+// zeroed and returned as missing, as long as some other chunk could be read.
+func (l *Logger) readBlock(address, quantity uint16) ([]byte, []span, error) {
+	res := make([]byte, 0, int(quantity)*2)
+	var missing []span
+	var illegalErr error
+	read := false
+	step := uint16(l.maxRegs)
+	for offset := uint16(0); offset < quantity; offset += step {
+		n := min(step, quantity-offset)
+		chunk, err := l.readChunk(address+offset, n, read)
+		if isIllegalAddress(err) {
+			log.Debugf("Registers %d to %d unavailable: %v", address+offset, address+offset+n-1, err)
+			missing = append(missing, span{start: len(res), end: len(res) + int(n)*2})
+			res = append(res, make([]byte, int(n)*2)...)
+			illegalErr = err
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		res = append(res, chunk...)
+		read = true
+	}
+	if !read {
+		return nil, nil, illegalErr
+	}
+	return res, missing, nil
+}
+
+// readChunk reads a single chunk of registers, ignoring any extra trailing
+// bytes. A chunk that is too short, or that fails after an earlier chunk of
+// the block succeeded, is retried since the meter is evidently there.
+func (l *Logger) readChunk(address, quantity uint16, partial bool) ([]byte, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var res []byte
+		res, err = l.readRegisters(address, quantity, l.inputRegisters)
+		if err == nil && len(res) > int(quantity)*2 {
+			log.Debugf("Ignoring %d extra bytes reading %d registers at %d", len(res)-int(quantity)*2, quantity, address)
+			res = res[:int(quantity)*2]
+		}
+		if err == nil && len(res) == int(quantity)*2 {
+			return res, nil
+		}
+		if err == nil {
+			err = &lengthError{expected: int(quantity) * 2, actual: len(res)}
+		}
+		var lengthErr *lengthError
+		retry := errors.As(err, &lengthErr) ||
+			(partial && !isDeviceGone(err) && !isIllegalAddress(err) && !errors.Is(err, errClosed))
+		if !retry || attempt == chunkRetries {
+			return nil, err
+		}
+		delay := l.retryDelay()
+		log.Debugf("Retrying read of %d registers at %d in %v: %v", quantity, address, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// read reads the register block. Meters rejecting the whole block with an
+// illegal address exception are read in minimal mode from then on.
+func (l *Logger) read() ([]byte, []span, error) {
+	if !l.minimal.Load() {
+		res, missing, err := l.readBlock(0, l.blockSize)
+		if !isIllegalAddress(err) {
+			return res, missing, err
+		}
+		log.Warnf("%v rejected the register block, reading only the core measurements: %v", l.name, err)
+	}
+	res, missing, err := l.readMinimal()
+	if err == nil && !l.minimal.Swap(true) {
+		l.readMode.WithLabelValues(modeFull).Set(0)
+		l.readMode.WithLabelValues(modeMinimal).Set(1)
+	}
+	return res, missing, err
+}
+
+// readMinimal reads the coreBlocks into a block of the full size, with the
+// registers in between marked as missing
+func (l *Logger) readMinimal() ([]byte, []span, error) {
+	res := make([]byte, int(l.blockSize)*2)
+	var missing []span
+	next := 0
+	for _, b := range coreBlocks {
+		if b.address >= l.blockSize {
+			break
+		}
+		start := int(b.address) * 2
+		if start > next {
+			missing = append(missing, span{start: next, end: start})
+		}
+		chunk, chunkMissing, err := l.readBlock(b.address, min(b.quantity, l.blockSize-b.address))
+		if err != nil {
+			return nil, nil, err
+		}
+		copy(res[start:], chunk)
+		for _, s := range chunkMissing {
+			missing = append(missing, span{start: start + s.start, end: start + s.end})
+		}
+		next = start + len(chunk)
+	}
+	if next < len(res) {
+		missing = append(missing, span{start: next, end: len(res)})
+	}
+	return res, missing, nil
+}
+
+// retryDelay returns a random delay up to the retry jitter, so loggers on a
+// shared bus retrying after the same disturbance do not collide again
+func (l *Logger) retryDelay() time.Duration {
+	if l.retryJitter <= 0 {
+		return 0
+	}
+	return rand.N(l.retryJitter)
+}
+
 func (l *Logger) update() error {
-	res, err := l.client.ReadHoldingRegisters(0, readSize)
-	if err != nil {
-		l.errorEvent()
+	l.updateMu.Lock()
+	defer l.updateMu.Unlock()
+	if w := l.preReadWrite; w != nil {
+		if err := l.writeRegister(w.address, w.value); err != nil {
+			if isDeviceGone(err) {
+				l.errorEvent(classDeviceGone)
+				return fmt.Errorf("serial device gone: %v", err)
+			}
+			l.errorEvent(classRead)
+			return fmt.Errorf("could not write %v to register %v before reading: %v", w.value, w.address, err)
+		}
+	}
+	start := time.Now()
+	res, missing, err := l.read()
+	l.readDuration.Observe(time.Since(start).Seconds())
+	var lengthErr *lengthError
+	switch {
+	case errors.As(err, &lengthErr) && lengthErr.actual == 0:
+		l.errorEvent(classInvalidLength)
+		return err
+	case errors.As(err, &lengthErr):
+		log.Debugf("Length mismatch reading %v: got %d bytes, expected %d", l.name, lengthErr.actual, lengthErr.expected)
+		l.errorEvent(classLengthMismatch)
+		return err
+	case err != nil && isDeviceGone(err):
+		l.errorEvent(classDeviceGone)
+		return fmt.Errorf("serial device gone: %v", err)
+	case err != nil:
+		l.errorEvent(classRead)
 		return fmt.Errorf("could not read values: %v", err)
 	}
-	if len(res) != readSize*2 {
-		l.errorEvent()
-		return fmt.Errorf("invalid read size: %v", len(res))
+
+	log.Debugf("Read %v: % x", l.name, res)
+	if l.rawRegisters != nil {
+		for offset := 0; offset < len(res); offset += 2 {
+			if isMissing(missing, offset) {
+				continue
+			}
+			l.rawRegisters.WithLabelValues(strconv.Itoa(offset)).Set(float64(binary.BigEndian.Uint16(res[offset:])))
+		}
 	}
 
+	reading := Reading{Device: l.name, Time: time.Now(), Values: map[string]float64{}}
+	l.sampleTime.Store(reading.Time.UnixNano())
 	for _, g := range l.gauges {
+		if isMissing(missing, g.register) {
+			if g.sticky {
+				// Held channels keep their last value, as on read errors
+				continue
+			}
+			g.Set(math.NaN())
+			if g.omitted != nil {
+				g.omitted.Store(true)
+			}
+			continue
+		}
 		value := g.valueFunc(res, g.register, g.scale)
+		log.Debugf("Decoded %v %v: %v", l.name, g.channel, value)
+		if g.omitted != nil {
+			g.omitted.Store(value == 0)
+			if value == 0 {
+				continue
+			}
+		}
 		if g.filter != nil {
-			value = g.filter(value, time.Now())
+			raw := value
+			value = g.filter.filter(raw, time.Now())
+			active := 0.0
+			if value != raw {
+				log.Debugf("Filter rejected %v %v reading %v, keeping %v", l.name, g.channel, raw, value)
+				active = 1
+			}
+			l.filterActive.WithLabelValues(g.channel).Set(active)
+		}
+		switch g.channel {
+		case "power_factor":
+			// Before the range check, which discards the readings of a wrong scale
+			l.checkPowerFactor(value, g.scale)
+		case "voltage":
+			// Before the range check, which discards the deepest sags and swells
+			l.voltageEvents.update(value)
+		}
+		if g.max > g.min && (value < g.min || value > g.max) {
+			log.Warnf("Discarding out of range %v reading: %v", g.channel, value)
+			l.rangeViolations.WithLabelValues(g.channel).Inc()
+			continue
 		}
 		g.Set(value)
+		reading.Values[g.channel] = value
+		if g.channel == "active_energy" && l.averagePower != nil {
+			l.averagePower.update(reading.Time, value)
+		}
+	}
+
+	if l.pfCheck != nil {
+		l.pfCheck.update(l.name, reading.Values)
+	}
+	if l.netEnergy != nil {
+		l.netEnergy.update(reading.Values)
+	}
+
+	l.readingMu.Lock()
+	l.reading = reading
+	l.readingMu.Unlock()
+	l.stale.Set(0)
+	l.healthy.Store(true)
+	l.failures.Store(0)
+	l.lastSuccess.Store(reading.Time.UnixNano())
+
+	for _, s := range l.sinks {
+		if err := s.Write(reading); err != nil {
+			log.Errorf("Could not write reading: %v", err)
+		}
 	}
 	return nil
 }
 
-func (l *Logger) errorEvent() {
+// hasChannels reports whether all of the channels are exported
+func (l *Logger) hasChannels(channels ...string) bool {
+	for _, c := range channels {
+		found := false
+		for _, g := range l.gauges {
+			found = found || g.channel == c
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterStates returns the state of the energy filter of every filtered
+// channel, for troubleshooting energy readings that do not change
+func (l *Logger) FilterStates() []FilterState {
+	var states []FilterState
+	for _, g := range l.gauges {
+		if g.filter == nil {
+			continue
+		}
+		s := g.filter.state()
+		s.Channel = g.channel
+		states = append(states, s)
+	}
+	return states
+}
+
+// Healthy reports whether the last poll of the device succeeded
+func (l *Logger) Healthy() bool {
+	return l.healthy.Load()
+}
+
+// Ready reports whether the device was read successfully within the last
+// few poll intervals. Reading on scrape, where reads follow the scrapes, it
+// reports whether the last read succeeded. It never reads the device itself.
+func (l *Logger) Ready() bool {
+	last := l.lastSuccess.Load()
+	if l.lazy != nil {
+		return last != 0 && l.healthy.Load()
+	}
+	return last != 0 && time.Since(time.Unix(0, last)) <= readyPolls*l.pollRate
+}
+
+// checkPowerFactor warns once if the power factor is impossibly large, which
+// almost always means its scale is wrong
+func (l *Logger) checkPowerFactor(pf, scale float64) {
+	if math.Abs(pf) <= 1+pfTolerance || l.pfWarned.Swap(true) {
+		return
+	}
+	suggested := scale * math.Pow(10, math.Ceil(math.Log10(math.Abs(pf))))
+	log.Warnf("%v power factor %v is above 1, its scale of %v is likely wrong, a scale of %v would give %.3f",
+		l.name, pf, scale, suggested, pf*scale/suggested)
+}
+
+// ReadOnce polls the device once and returns the decoded values
+func (l *Logger) ReadOnce() (Reading, error) {
+	if err := l.update(); err != nil {
+		return Reading{}, err
+	}
+	l.readingMu.Lock()
+	defer l.readingMu.Unlock()
+	return l.reading, nil
+}
+
+// Snapshot returns the reading of the last successful poll, with a zero Time
+// if there was none yet. It is safe to call while the poller runs.
+func (l *Logger) Snapshot() Reading {
+	l.readingMu.Lock()
+	defer l.readingMu.Unlock()
+	r := l.reading
+	r.Values = maps.Clone(r.Values)
+	return r
+}
+
+func (l *Logger) errorEvent(class string) {
 	l.readFailures.Add(1)
+	l.errorClass.WithLabelValues(class).Add(1)
+	l.sampleTime.Store(time.Now().UnixNano())
+	l.stale.Set(1)
+	l.healthy.Store(false)
+	if l.failures.Add(1) < int64(l.zeroAfter) {
+		return
+	}
 	for _, g := range l.gauges {
 		if !g.sticky {
 			g.Set(0)
+			if g.omitted != nil {
+				g.omitted.Store(true)
+			}
 		}
 	}
+	if l.netEnergy != nil && !l.netEnergy.sticky {
+		l.netEnergy.gauge.Set(0)
+	}
 }
 
-// Poller starts the polling of the new values device
+// ResetErrors sets the read error counts back to zero
+func (l *Logger) ResetErrors() {
+	l.readFailures.Set(0)
+	for _, c := range errorClasses {
+		l.errorClass.WithLabelValues(c).Set(0)
+	}
+}
+
+// Clock reads the real time clock of the device
+func (l *Logger) Clock() (time.Time, error) {
+	res, err := l.readRegisters(TimeReg/2, clockSize, false)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read clock: %v", err)
+	}
+	if len(res) != clockSize*2 {
+		return time.Time{}, fmt.Errorf("invalid clock read size: %v", len(res))
+	}
+	return getClock(res, 0), nil
+}
+
+// SetClock writes t to the real time clock of the device
+func (l *Logger) SetClock(t time.Time) error {
+	data := make([]byte, clockSize*2)
+	binary.BigEndian.PutUint64(data, uint64(t.Unix()))
+	if err := l.writeRegisters(TimeReg/2, clockSize, data); err != nil {
+		return fmt.Errorf("could not write clock: %v", err)
+	}
+	return nil
+}
+
+// WriteRegister writes value to the holding register at address, such as a
+// CT or PT ratio setting, and reads it back to verify the meter took it
+func (l *Logger) WriteRegister(address, value uint16) error {
+	if err := l.writeRegister(address, value); err != nil {
+		return fmt.Errorf("could not write register %v: %v", address, err)
+	}
+	res, err := l.readRegisters(address, 1, false)
+	if err != nil {
+		return fmt.Errorf("could not read back register %v: %v", address, err)
+	}
+	if len(res) < 2 {
+		return fmt.Errorf("could not read back register %v: %v", address, &lengthError{expected: 2, actual: len(res)})
+	}
+	if got := binary.BigEndian.Uint16(res); got != value {
+		return fmt.Errorf("register %v reads %v after writing %v", address, got, value)
+	}
+	return nil
+}
+
+// Poller starts the polling of the new values device. A watchdog restarts
+// the polling if no poll has happened for a few poll intervals.
 func (l *Logger) Poller() {
+	_ = l.Start()
+}
+
+// Start starts the polling like Poller and returns the error of the first
+// poll. The polling goes on after an error, so callers not giving up on it
+// keep retrying until the device answers.
+func (l *Logger) Start() error {
+	err := l.poll()
+	abort := l.startPollLoop()
 	l.wg.Add(1)
-	defer l.wg.Done()
-	ticker := time.NewTicker(time.Second * pollRateSec)
-	if err := l.update(); err != nil {
+	go l.watchdog(abort)
+	return err
+}
+
+// PollerCtx polls like Poller until ctx is done, then stops the polling like
+// Close and returns. It also returns once Close is called.
+func (l *Logger) PollerCtx(ctx context.Context) {
+	l.Poller()
+	select {
+	case <-ctx.Done():
+		l.Close()
+	case <-l.stop:
+	}
+}
+
+// poll updates the values, logging and returning any error
+func (l *Logger) poll() error {
+	now := time.Now()
+	l.lastTick.Store(now.UnixNano())
+	l.lastPoll.Set(float64(now.UnixNano()) / float64(time.Second))
+	err := l.update()
+	if err != nil {
 		log.Errorf("Could not update values: %v", err)
 	}
-	go func() {
-		for {
+	if l.reconnect == nil {
+		return err
+	}
+	if err != nil {
+		l.reconnect.failed(now)
+	} else {
+		l.reconnect.succeeded()
+	}
+	return err
+}
+
+func (l *Logger) startPollLoop() chan struct{} {
+	abort := make(chan struct{})
+	l.lastTick.Store(time.Now().UnixNano())
+	l.wg.Add(1)
+	go l.pollLoop(abort)
+	return abort
+}
+
+func (l *Logger) pollLoop(abort chan struct{}) {
+	defer l.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Poller stopped: %v", r)
+		}
+	}()
+	if l.alignTicks {
+		// The ticker keeps the period, so only its start needs aligning
+		now := time.Now()
+		wait := time.NewTimer(now.Truncate(l.pollRate).Add(l.pollRate).Sub(now))
+		defer wait.Stop()
+		select {
+		case <-wait.C:
+		case <-abort:
+			return
+		case <-l.stop:
+			return
+		}
+		_ = l.poll()
+	}
+	ticker := time.NewTicker(l.pollRate)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
 			select {
-			case <-ticker.C:
-				if err := l.update(); err != nil {
-					log.Errorf("Could not update values: %v", err)
-				}
 			case <-l.stop:
-				ticker.Stop()
 				return
+			default:
 			}
+			_ = l.poll()
+		case <-abort:
+			return
+		case <-l.stop:
+			return
 		}
-	}()
+	}
 }
 
-// Close stops the poller
+func (l *Logger) watchdog(abort chan struct{}) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.pollRate)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			since := time.Since(time.Unix(0, l.lastTick.Load()))
+			if since > watchdogFactor*l.pollRate {
+				log.Errorf("No poll in %v, restarting poller", since)
+				close(abort)
+				abort = l.startPollLoop()
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Close stops the poller. It waits for any transaction in progress to
+// complete and refuses new ones, so the client can safely be closed after.
+// Closing again has no effect.
 func (l *Logger) Close() {
-	close(l.stop)
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
 	l.wg.Wait()
+	l.busMu.Lock()
+	l.closed = true
+	l.busMu.Unlock()
 }
 
 func get16BitValue(data []byte, offset int, scale float64) float64 {
 	return float64(binary.BigEndian.Uint16(data[offset:offset+2])) / scale
 }
 
+// get16BitSignedValue decodes a two's complement register, for values that go
+// negative when exporting to the grid
+func get16BitSignedValue(data []byte, offset int, scale float64) float64 {
+	return float64(int16(binary.BigEndian.Uint16(data[offset:offset+2]))) / scale
+}
+
+// get64BitValue decodes four registers as one Big Endian number, such as the
+// Unix time of the device clock
+func get64BitValue(data []byte, offset int, scale float64) float64 {
+	return float64(binary.BigEndian.Uint64(data[offset:offset+8])) / scale
+}
+
+// getFloat32Value decodes two registers as a Big Endian IEEE-754 float
+func getFloat32Value(data []byte, offset int, scale float64) float64 {
+	return float64(math.Float32frombits(binary.BigEndian.Uint32(data[offset:offset+4]))) / scale
+}
+
+// getFloat32SwappedValue decodes two registers as an IEEE-754 float with the
+// low word first, as some meters send it
+func getFloat32SwappedValue(data []byte, offset int, scale float64) float64 {
+	low := uint32(binary.BigEndian.Uint16(data[offset : offset+2]))
+	high := uint32(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+	return float64(math.Float32frombits(high<<16|low)) / scale
+}
+
 func get32BitEnergy(data []byte, offset int, scale float64) float64 {
-	// The time binned data is ignored as the internal clock is never set
-	// The layout for the energy mapping is 5 x 32 Big Endian Numbers
+	// The layout for the energy mapping is 5 x 32 Big Endian Numbers, the
+	// total followed by the time binned tariffs. The bins only fill up while
+	// the clock and time slots are set.
 	return float64(binary.BigEndian.Uint32(data[offset:offset+4])) / scale
 }
+
+func getClock(data []byte, offset int) time.Time {
+	// The clock is stored as 4 x 16 bit registers holding the Unix time in
+	// seconds as a Big Endian Number
+	return time.Unix(int64(binary.BigEndian.Uint64(data[offset:offset+8])), 0)
+}
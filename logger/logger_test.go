@@ -1,11 +1,26 @@
 package logger
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,29 +37,1309 @@ func TestClose(t *testing.T) {
 	l.Close()
 }
 
+func TestStart(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		err:      errors.New("error"),
+	}
+	l, err := New(m, "tester-start")
+	assert.NoError(t, err, "Could not create logger")
+	assert.Error(t, l.Start(), "First read error not returned")
+	l.Close()
+	assert.False(t, l.Healthy(), "Healthy after a failed first read")
+
+	m.err = nil
+	l, err = New(m, "tester-start-ok")
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.Start(), "Unexpected first read error")
+	l.Close()
+}
+
+func TestPollerCtx(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-poller-ctx")
+	assert.NoError(t, err, "Could not create logger")
+	l.pollRate = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.PollerCtx(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PollerCtx did not return after the context was cancelled")
+	}
+	reads := m.reads.Load()
+	assert.Greater(t, reads, int32(1), "Not polled")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, reads, m.reads.Load(), "Polled after the context was cancelled")
+	l.Close()
+}
+
+func TestWatchdog(t *testing.T) {
+	m := &mockModbus{
+		readData:   make([]byte, readSize*2),
+		panicAfter: 2,
+	}
+	l, err := New(m, "tester-watchdog")
+	assert.NoError(t, err, "Could not create logger")
+	l.pollRate = 10 * time.Millisecond
+	l.Poller()
+	time.Sleep(200 * time.Millisecond)
+	l.Close()
+	assert.Greater(t, m.reads.Load(), int32(5), "Poller not restarted after panic")
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		block:    make(chan struct{}),
+		blocked:  make(chan struct{}, 1),
+	}
+	l, err := New(m, "tester-concurrent-updates", WithAveragePower(time.Minute))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = l.update()
+		}()
+	}
+	<-m.blocked
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), m.reads.Load(), "Update started while another was in progress")
+	close(m.block)
+	wg.Wait()
+	assert.Equal(t, int32(2), m.reads.Load(), "Waiting update not run")
+}
+
+func TestCloseDuringRead(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		block:    make(chan struct{}),
+		blocked:  make(chan struct{}, 1),
+	}
+	l, err := New(m, "tester-close-read")
+	assert.NoError(t, err, "Could not create logger")
+	l.pollRate = 10 * time.Millisecond
+	l.startPollLoop()
+	<-m.blocked
+
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a read was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(m.block)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the read completed")
+	}
+	assert.Equal(t, int32(1), m.reads.Load(), "No reads expected after close")
+	_, err = l.Clock()
+	assert.Error(t, err, "Error expected from clock read after close")
+}
+
+func TestBlockedDeviceIsolation(t *testing.T) {
+	blocked := &mockModbus{
+		readData: make([]byte, readSize*2),
+		block:    make(chan struct{}),
+		blocked:  make(chan struct{}, 1),
+	}
+	healthy := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	lb, err := New(blocked, "tester-isolation-blocked")
+	assert.NoError(t, err, "Could not create logger")
+	lh, err := New(healthy, "tester-isolation-healthy")
+	assert.NoError(t, err, "Could not create logger")
+	lb.pollRate = 10 * time.Millisecond
+	lh.pollRate = 10 * time.Millisecond
+
+	lb.startPollLoop()
+	<-blocked.blocked
+	lh.startPollLoop()
+	time.Sleep(100 * time.Millisecond)
+	assert.Greater(t, healthy.reads.Load(), int32(3), "Healthy device not polled while the other is blocked")
+
+	close(blocked.block)
+	lb.Close()
+	lh.Close()
+}
+
 func TestReadError(t *testing.T) {
 	m := &mockModbus{
 		readData: make([]byte, readSize*2),
-		err:      errors.New("error"),
+		err:      errors.New("error"),
+	}
+	l, err := New(m, "tester-2")
+	assert.NoError(t, err, "Could not create logger")
+	err = l.update()
+	assert.Error(t, err, "Error expected from update")
+	l.Close()
+}
+
+func TestDeviceGone(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		err:      &os.PathError{Op: "read", Path: "/dev/ttyUSB0", Err: syscall.ENODEV},
+	}
+	l, err := New(m, "tester-gone")
+	assert.NoError(t, err, "Could not create logger")
+	err = l.update()
+	assert.ErrorContains(t, err, "serial device gone", "Device error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, l.errorClass.WithLabelValues(classDeviceGone)), "Device error not counted")
+	assert.Equal(t, 0.0, metricValue(t, l.errorClass.WithLabelValues(classRead)), "Read error not expected")
+
+	m.err = errors.New("timeout")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, l.errorClass.WithLabelValues(classRead)), "Read error not counted")
+	l.Close()
+}
+
+func TestChunkRetry(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	data[TemperatureReg], data[TemperatureReg+1] = 0x01, 0xa4
+	m := &mockModbus{
+		readData:  data,
+		addressed: true,
+		failOnce:  map[uint16]bool{20: true},
+	}
+	l, err := New(m, "tester-chunk")
+	assert.NoError(t, err, "Could not create logger")
+	l.maxRegs = 20
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Failed chunk not retried")
+	assert.Equal(t, int32(3), m.reads.Load(), "Only the failed chunk should be retried")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read from first chunk")
+	assert.InDelta(t, 42, r.Values["temperature"], 0.0001, "Temperature not read from second chunk")
+	assert.Equal(t, 0.0, metricValue(t, l.readFailures), "No read failure expected")
+	l.Close()
+}
+
+func TestPollRate(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-poll-rate", WithPollRate(30*time.Second))
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, 30*time.Second, l.pollRate, "Poll rate not set")
+	l.Close()
+
+	_, err = New(m, "tester-poll-rate-fast", WithPollRate(100*time.Millisecond))
+	assert.Error(t, err, "Poll rates below 1s should be rejected")
+}
+
+type mockConnection struct {
+	connects int
+	closes   int
+}
+
+func (c *mockConnection) Connect() error {
+	c.connects++
+	return nil
+}
+
+func (c *mockConnection) Close() error {
+	c.closes++
+	return nil
+}
+
+func TestReconnect(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		err:      errors.New("mock timeout"),
+	}
+	conn := &mockConnection{}
+	count := prometheus.NewCounter(prometheus.CounterOpts{Name: "tester_reconnects_total"})
+	r := NewReconnector(conn, "bus", pollRateSec*time.Second, count)
+	l, err := New(m, "tester-reconnect", WithReconnect(r), WithRetryJitter(0))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+
+	for i := 0; i < reconnectFailures; i++ {
+		_ = l.poll()
+	}
+	assert.Equal(t, 1, conn.connects, "Not reconnected after consecutive failures")
+	assert.Equal(t, 1, conn.closes, "Not closed before reconnecting")
+	_ = l.poll()
+	assert.Equal(t, 1, conn.connects, "Reconnected again within the backoff")
+	assert.Equal(t, 2*l.pollRate, l.reconnect.backoff, "Backoff not doubled")
+
+	l.reconnect.next = time.Now()
+	_ = l.poll()
+	assert.Equal(t, 2, conn.connects, "Not reconnected after the backoff")
+	assert.Equal(t, 2.0, metricValue(t, count), "Reconnects not counted")
+
+	shared, err := New(m, "tester-reconnect-shared", WithReconnect(r), WithRetryJitter(0))
+	assert.NoError(t, err, "Could not create logger")
+	defer shared.Close()
+	_ = l.poll()
+	_ = l.poll()
+	m.err = nil
+	_ = shared.poll()
+	m.err = errors.New("mock timeout")
+	_ = l.poll()
+	assert.Equal(t, 1, r.failures, "Failures not reset by a successful poll of another slave on the bus")
+
+	m.err = nil
+	_ = l.poll()
+	assert.Equal(t, l.pollRate, l.reconnect.backoff, "Backoff not reset by a successful poll")
+	assert.Equal(t, 0, l.reconnect.failures, "Failures not reset by a successful poll")
+}
+
+func TestEnergyFilterMaxCurrent(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-filter-current", WithEnergyFilterMaxCurrent(400))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	for _, g := range l.gauges {
+		if g.filter != nil {
+			assert.Equal(t, newEnergyFilter(400, avgVoltage, 0, false).maxIncrease, g.filter.maxIncrease, "Max current not applied to %v", g.channel)
+		}
+	}
+
+	registers := append(DefaultRegisterMap(), Measurement{
+		Channel: "tariff_energy", Name: "mains_tariff_energy", Unit: "kwh", Offset: TsReg,
+		Scale: 100, Value: get16BitValue, Cumulative: true, MaxCurrent: 50,
+	})
+	l2, err := New(m, "tester-filter-current-own", WithEnergyFilterMaxCurrent(400), WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	defer l2.Close()
+	tariff := l2.gauges[len(l2.gauges)-1]
+	assert.Equal(t, newEnergyFilter(50, avgVoltage, 0, false).maxIncrease, tariff.filter.maxIncrease, "Measurement max current overridden")
+
+	_, err = New(m, "tester-filter-current-zero", WithEnergyFilterMaxCurrent(0))
+	assert.Error(t, err, "Max current of 0 should be rejected")
+}
+
+func TestAlignedPolls(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-aligned", WithAlignedPolls(), WithPollRate(time.Second))
+	assert.NoError(t, err, "Could not create logger")
+	l.Poller()
+	assert.Eventually(t, func() bool { return m.reads.Load() >= 2 }, 3*time.Second, 10*time.Millisecond, "No aligned poll")
+	l.Close()
+	tick := time.Unix(0, l.lastTick.Load())
+	offset := tick.Sub(tick.Truncate(time.Second))
+	assert.Less(t, offset, 100*time.Millisecond, "Poll not aligned to the second: %v", tick)
+}
+
+func TestReady(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-ready")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.False(t, l.Ready(), "Ready before the first read")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.True(t, l.Ready(), "Not ready after a read")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.True(t, l.Ready(), "Not ready right after a failed read")
+	l.lastSuccess.Store(time.Now().Add(-readyPolls*l.pollRate - time.Second).UnixNano())
+	assert.False(t, l.Ready(), "Ready without a recent successful read")
+
+	m.err = nil
+	lazy, err := New(m, "tester-ready-lazy", WithReadOnScrape(time.Second))
+	reads := m.reads.Load()
+	assert.NoError(t, err, "Could not create logger")
+	defer lazy.Close()
+	assert.False(t, lazy.Ready(), "Ready before the first scrape")
+	assert.Equal(t, int32(0), m.reads.Load()-reads, "Read for readiness")
+	lazy.lazy.refresh()
+	assert.True(t, lazy.Ready(), "Not ready after a scrape read")
+	m.err = errors.New("error")
+	lazy.lazy.last = time.Time{}
+	lazy.lazy.refresh()
+	assert.False(t, lazy.Ready(), "Ready after a failed scrape read")
+}
+
+func TestRetryJitter(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-jitter", WithRetryJitter(10*time.Millisecond))
+	assert.NoError(t, err, "Could not create logger")
+	for i := 0; i < 100; i++ {
+		d := l.retryDelay()
+		assert.True(t, d >= 0 && d < 10*time.Millisecond, "Retry delay %v out of range", d)
+	}
+	l.retryJitter = 0
+	assert.Equal(t, time.Duration(0), l.retryDelay(), "No delay expected without jitter")
+	l.Close()
+
+	_, err = New(m, "tester-jitter-negative", WithRetryJitter(-time.Second))
+	assert.Error(t, err, "Negative jitter should be rejected")
+}
+
+func TestMinimalRead(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	data[TemperatureReg+1] = 250
+	m := &mockModbus{
+		readData:    data,
+		addressed:   true,
+		maxQuantity: TsReg / 2,
+	}
+	l, err := New(m, "tester-minimal")
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, 1.0, metricValue(t, l.readMode.WithLabelValues(modeFull)), "Full mode should be active initially")
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Minimal read should succeed")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read in minimal mode")
+	assert.Equal(t, 25.0, r.Values["temperature"], "Temperature not read in minimal mode")
+	assert.Equal(t, 1.0, metricValue(t, l.readMode.WithLabelValues(modeMinimal)), "Minimal mode not reported")
+	assert.Equal(t, 0.0, metricValue(t, l.readMode.WithLabelValues(modeFull)), "Full mode still reported")
+
+	reads := m.reads.Load()
+	_, err = l.ReadOnce()
+	assert.NoError(t, err, "Minimal read should succeed")
+	assert.Equal(t, int32(len(coreBlocks)), m.reads.Load()-reads, "Full block should not be retried in minimal mode")
+	l.Close()
+}
+
+func TestRawRegisters(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[CurrentReg], data[CurrentReg+1] = 0x01, 0x10
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-raw", WithRawRegisters())
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "No update error expected")
+	assert.Equal(t, 272.0, metricValue(t, l.rawRegisters.WithLabelValues("2")), "Raw register not exported")
+	l.Close()
+}
+
+func TestUnavailableChunk(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{
+		readData:  data,
+		addressed: true,
+		illegal:   map[uint16]bool{20: true},
+	}
+	l, err := New(m, "tester-unavailable")
+	assert.NoError(t, err, "Could not create logger")
+	l.maxRegs = 20
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unavailable chunk should not fail the read")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read from first chunk")
+	assert.NotContains(t, r.Values, "temperature", "Temperature should be unavailable")
+	assert.True(t, math.IsNaN(metricValue(t, l.gauges[len(l.gauges)-1])), "Unavailable temperature should be NaN")
+
+	// Held channels in an unavailable chunk keep their last value
+	binary.BigEndian.PutUint32(data[ReactiveEnergyReg+8:], 1234)
+	delete(m.illegal, 20)
+	_, err = l.ReadOnce()
+	assert.NoError(t, err, "Could not read all chunks")
+	m.illegal[20] = true
+	r, err = l.ReadOnce()
+	assert.NoError(t, err, "Unavailable chunk should not fail the read")
+	assert.NotContains(t, r.Values, "reactive_energy_tariff2", "Unavailable tariff should not be read")
+	for _, g := range l.gauges {
+		if g.channel == "reactive_energy_tariff2" {
+			assert.Equal(t, 12.34, metricValue(t, g), "Held tariff not kept")
+		}
+	}
+
+	m.illegal[0] = true
+	_, err = l.ReadOnce()
+	assert.Error(t, err, "Error expected if no chunk is available")
+	l.Close()
+}
+
+func TestReadOnScrape(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-scrape", WithReadOnScrape(time.Minute))
+	assert.NoError(t, err, "Could not create logger")
+	c := &lazyCollector{l: l, inner: gaugeCollector{l: l}, maxAge: time.Minute}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch := make(chan prometheus.Metric, 2*len(l.gauges))
+			c.Collect(ch)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), m.reads.Load(), "Concurrent scrapes should share one read")
+	assert.InDelta(t, 230, metricValue(t, l.gauges[0]), 0.0001, "Voltage not read on scrape")
+
+	c.last = time.Time{}
+	c.Collect(make(chan prometheus.Metric, 2*len(l.gauges)))
+	assert.Equal(t, int32(2), m.reads.Load(), "Scrape after max age should read again")
+	l.Close()
+}
+
+func TestTimestamps(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-timestamps", WithTimestamps())
+	assert.NoError(t, err, "Could not create logger")
+	c := timestampCollector{l: l}
+
+	ch := make(chan prometheus.Metric, 2*len(l.gauges))
+	c.Collect(ch)
+	out := &dto.Metric{}
+	assert.NoError(t, (<-ch).Write(out), "Could not write metric")
+	assert.Nil(t, out.TimestampMs, "No timestamp expected before the first read")
+
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "No update error expected")
+	ch = make(chan prometheus.Metric, 2*len(l.gauges))
+	c.Collect(ch)
+	assert.NoError(t, (<-ch).Write(out), "Could not write metric")
+	assert.Equal(t, r.Time.UnixMilli(), out.GetTimestampMs(), "Read time not used as timestamp")
+	l.Close()
+}
+
+func TestReadInvalidLength(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, 1),
+	}
+	l, err := New(m, "tester-3")
+	assert.NoError(t, err, "Could not create logger")
+	err = l.update()
+	assert.Error(t, err, "Error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, l.errorClass.WithLabelValues(classLengthMismatch)), "Short read not counted as length mismatch")
+	assert.Equal(t, 0.0, metricValue(t, l.errorClass.WithLabelValues(classInvalidLength)), "Short read counted as empty read")
+
+	m.readData = nil
+	err = l.update()
+	assert.Error(t, err, "Error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, l.errorClass.WithLabelValues(classInvalidLength)), "Empty read not counted")
+	l.Close()
+}
+
+func TestReadLongerThanRequested(t *testing.T) {
+	data := make([]byte, readSize*2+3)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-long-read")
+	assert.NoError(t, err, "Could not create logger")
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Extra trailing bytes should not fail the read")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not decoded from the front of the read")
+	l.Close()
+}
+
+func TestDataStale(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-stale")
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, 1.0, metricValue(t, l.stale), "Data should be stale before the first read")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.Equal(t, 0.0, metricValue(t, l.stale), "Data should be fresh after a read")
+	assert.True(t, l.Healthy(), "Logger should be healthy after a read")
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, l.stale), "Data should be stale after a failed read")
+	assert.False(t, l.Healthy(), "Logger should be unhealthy after a failed read")
+	l.Close()
+}
+
+func TestResetErrors(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+		err:      errors.New("error"),
+	}
+	l, err := New(m, "tester-reset")
+	assert.NoError(t, err, "Could not create logger")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 2.0, metricValue(t, l.readFailures), "Read failures not counted")
+	l.ResetErrors()
+	assert.Equal(t, 0.0, metricValue(t, l.readFailures), "Read failures not reset")
+	l.Close()
+}
+
+func TestSerialNumberName(t *testing.T) {
+	m := &mockModbus{
+		readData: []byte{0x00, 0x01, 0xe2, 0x40},
+	}
+	l, err := New(m, "tester-serial", WithSerialNumberName(0x40))
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, "123456", l.Name(), "Serial number not used as name")
+	l.Close()
+
+	m.err = errors.New("error")
+	l, err = New(m, "tester-serial-fallback", WithSerialNumberName(0x40))
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, "tester-serial-fallback", l.Name(), "Device name not used as fallback")
+	l.Close()
+}
+
+func TestScaleInfo(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-scale-info")
+	assert.NoError(t, err, "Could not create logger")
+	assert.Equal(t, 1.0, metricValue(t, l.scaleInfo.WithLabelValues("voltage", "10")), "Voltage scale not reported")
+	assert.Equal(t, 1.0, metricValue(t, l.scaleInfo.WithLabelValues("power_factor", "1000")), "Power factor scale not reported")
+	l.Close()
+}
+
+func TestStartupProbe(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize),
+	}
+	_, err := New(m, "tester-probe-short", WithStartupProbe())
+	assert.Error(t, err, "Short read should fail the startup probe")
+
+	m.readData = make([]byte, readSize*2)
+	l, err := New(m, "tester-probe", WithStartupProbe())
+	assert.NoError(t, err, "Complete read should pass the startup probe")
+	l.Close()
+}
+
+func TestRegisterMap(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	registers := DefaultRegisterMap()
+	registers[0].Help = "Line to neutral voltage"
+	registers[0].Unit = "volts"
+	l, err := New(m, "tester-registers", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	assert.Contains(t, l.gauges[0].Desc().String(), `fqName: "mains_voltage_volts"`, "Unit suffix not applied")
+	assert.Contains(t, l.gauges[0].Desc().String(), `help: "Line to neutral voltage"`, "Help text not applied")
+	assert.Contains(t, l.gauges[1].Desc().String(), `help: "Mains current"`, "Default help text changed")
+	l.Close()
+
+	registers = append(DefaultRegisterMap(), Measurement{Channel: "voltage", Name: "mains_other", Scale: 1, Value: get16BitValue})
+	_, err = New(m, "tester-registers-dup", WithRegisterMap(registers))
+	assert.Error(t, err, "Duplicate channel should be rejected")
+}
+
+func TestCumulativeFilter(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[TsReg+1] = 100
+	m := &mockModbus{readData: data}
+	registers := append(DefaultRegisterMap(), Measurement{
+		Channel:    "tariff_energy",
+		Name:       "mains_tariff_energy",
+		Unit:       "kwh",
+		Offset:     TsReg,
+		Scale:      100,
+		Value:      get16BitValue,
+		Cumulative: true,
+		Hold:       true,
+	})
+	l, err := New(m, "tester-cumulative", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	for _, g := range l.gauges {
+		if strings.HasSuffix(g.channel, "_energy") {
+			assert.NotNil(t, g.filter, "Energy channel %v not filtered", g.channel)
+			assert.True(t, g.sticky, "Energy channel %v not sticky", g.channel)
+		}
+	}
+	tariff := l.gauges[len(l.gauges)-1]
+
+	assert.NoError(t, l.update(), "Unexpected update error")
+	data[TsReg+1] = 50
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.Equal(t, 1.0, metricValue(t, tariff), "Decreasing energy not filtered")
+	assert.Equal(t, 1.0, metricValue(t, l.filterActive.WithLabelValues("tariff_energy")), "Filter not reported active")
+	assert.Equal(t, 0.0, metricValue(t, l.filterActive.WithLabelValues("active_energy")), "Filter reported active")
+	states := l.FilterStates()
+	assert.Len(t, states, 2+2*tariffs+1, "Unexpected number of filtered channels")
+	last := states[len(states)-1]
+	assert.Equal(t, "tariff_energy", last.Channel, "Unexpected channel")
+	assert.Equal(t, 1.0, last.LastAccepted, "Unexpected accepted value")
+	assert.Equal(t, 0.5, last.LastRejected, "Unexpected rejected value")
+	assert.Equal(t, rejectDecrease, last.Reason, "Unexpected rejection reason")
+	assert.Equal(t, 1, last.Rejections, "Unexpected rejection count")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 1.0, metricValue(t, tariff), "Energy not kept on read error")
+
+	out := &dto.Metric{}
+	assert.NoError(t, tariff.Write(out), "Could not write metric")
+	assert.NotNil(t, out.Counter, "Cumulative measurement not exported as a counter")
+	assert.Nil(t, out.Gauge, "Cumulative measurement exported as a gauge")
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err, "Could not gather metrics")
+	gathered := false
+	for _, f := range families {
+		if f.GetName() == "mains_tariff_energy_kwh" {
+			assert.Equal(t, dto.MetricType_COUNTER, f.GetType(), "Energy not gathered as a counter")
+			gathered = true
+		}
+	}
+	assert.True(t, gathered, "Energy not gathered")
+	l.Close()
+}
+
+func TestScaled(t *testing.T) {
+	registers, err := DefaultRegisterMap().Scaled(map[string]float64{"current": 100, "active_power": 10})
+	assert.NoError(t, err, "Could not scale registers")
+	assert.Equal(t, 100.0, registers[1].Scale, "Current scale not overridden")
+	assert.Equal(t, 10.0, registers[3].Scale, "Active power scale not overridden")
+	assert.Equal(t, 10.0, registers[0].Scale, "Voltage scale changed")
+	assert.Equal(t, 10.0, DefaultRegisterMap()[1].Scale, "Default map changed")
+
+	_, err = DefaultRegisterMap().Scaled(map[string]float64{"curent": 100})
+	assert.Error(t, err, "Unknown channel should be rejected")
+}
+
+func TestWithout(t *testing.T) {
+	registers, err := DefaultRegisterMap().Without("temperature", "clock", "reactive_power")
+	assert.NoError(t, err, "Could not disable channels")
+	assert.Len(t, registers, len(DefaultRegisterMap())-3, "Channels not removed")
+	assert.Equal(t, uint16(TsReg/2), registers.blockSize(), "Read not shortened")
+	assert.Equal(t, uint16(readSize), DefaultRegisterMap().blockSize(), "Default read shortened")
+
+	m := &mockModbus{
+		readData:  make([]byte, readSize*2),
+		addressed: true,
+	}
+	l, err := New(m, "tester-without", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.NotContains(t, r.Values, "temperature", "Disabled channel read")
+	assert.Contains(t, r.Values, "reactive_energy", "Enabled channel not read")
+	l.Close()
+
+	_, err = DefaultRegisterMap().Without("temprature")
+	assert.Error(t, err, "Unknown channel should be rejected")
+}
+
+func TestHolding(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[TemperatureReg+1] = 250
+	data[ActiveEnergyReg+3] = 100
+	m := &mockModbus{readData: data}
+	registers, err := DefaultRegisterMap().Holding("temperature")
+	assert.NoError(t, err, "Could not set holding channels")
+	l, err := New(m, "tester-holding", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	for _, g := range l.gauges {
+		switch g.channel {
+		case "temperature":
+			assert.Equal(t, 25.0, metricValue(t, g), "Temperature not held")
+		case "active_energy":
+			assert.Equal(t, 0.0, metricValue(t, g), "Active energy not zeroed")
+		}
+	}
+	l.Close()
+
+	_, err = DefaultRegisterMap().Holding("temprature")
+	assert.Error(t, err, "Unknown channel should be rejected")
+}
+
+func TestPowerFactorScale(t *testing.T) {
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint16(data[PowerFactorReg:], 950)
+	m := &mockModbus{readData: data}
+	registers, err := DefaultRegisterMap().Scaled(map[string]float64{"power_factor": 100})
+	assert.NoError(t, err, "Could not scale registers")
+	l, err := New(m, "tester-pf-scale", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.True(t, l.pfWarned.Load(), "Wrong power factor scale not flagged")
+	l.Close()
+
+	l, err = New(m, "tester-pf-scale-ok")
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.False(t, l.pfWarned.Load(), "Valid power factor flagged")
+	l.Close()
+}
+
+func TestKilo(t *testing.T) {
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint16(data[ActivePowerReg:], 2500)
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-kilo", WithRegisterMap(DefaultRegisterMap().Kilo()))
+	assert.NoError(t, err, "Could not create logger")
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.Equal(t, 2500.0, r.Values["active_power"], "Watt value changed")
+	assert.Equal(t, 2.5, r.Values["active_power_k"], "Kilowatt value not exported")
+	assert.Len(t, l.gauges, len(DefaultRegisterMap())+3, "Unexpected number of gauges")
+	assert.Contains(t, l.gauges[len(l.gauges)-1].Desc().String(), `fqName: "mains_apparent_power_kva"`, "Unexpected kilo metric name")
+	l.Close()
+
+	for _, m := range DefaultRegisterMap().Kilo() {
+		if strings.HasSuffix(m.Channel, "_k") {
+			assert.Empty(t, m.LegacyName, "Kilo measurement %v has a legacy name", m.Channel)
+		}
+	}
+}
+
+func TestLegacyNames(t *testing.T) {
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint16(data[ApparentPowerReg:], 1200)
+	m := &mockModbus{readData: data}
+	collected := func(l *Logger) map[string]float64 {
+		ch := make(chan prometheus.Metric, 2*len(l.gauges))
+		gaugeCollector{l: l}.Collect(ch)
+		close(ch)
+		values := map[string]float64{}
+		for metric := range ch {
+			values[metric.Desc().String()] = metricValue(t, metric)
+		}
+		return values
+	}
+	find := func(values map[string]float64, name string) (float64, bool) {
+		for desc, v := range values {
+			if strings.Contains(desc, `fqName: "`+name+`"`) {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+
+	l, err := New(m, "tester-legacy-names")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.NoError(t, l.update(), "Unexpected update error")
+	values := collected(l)
+	v, ok := find(values, "mains_apparent_power_va")
+	assert.True(t, ok, "Apparent power not exported")
+	assert.Equal(t, 1200.0, v, "Unexpected apparent power")
+	v, ok = find(values, "mains_appartent_power_va")
+	assert.True(t, ok, "Legacy apparent power name not exported")
+	assert.Equal(t, 1200.0, v, "Legacy name not exporting the same value")
+
+	without, err := New(m, "tester-legacy-names-off", WithRegisterMap(DefaultRegisterMap().WithoutLegacyNames()))
+	assert.NoError(t, err, "Could not create logger")
+	defer without.Close()
+	assert.NoError(t, without.update(), "Unexpected update error")
+	_, ok = find(collected(without), "mains_appartent_power_va")
+	assert.False(t, ok, "Legacy name exported after removing it")
+}
+
+func TestFahrenheit(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[TemperatureReg+1] = 250
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-fahrenheit", WithRegisterMap(DefaultRegisterMap().Fahrenheit()))
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	temperature := l.gauges[len(l.gauges)-1]
+	assert.Contains(t, temperature.Desc().String(), `fqName: "mains_device_temperature_f"`, "Unit suffix not changed")
+	assert.Equal(t, 77.0, metricValue(t, temperature), "Temperature not converted")
+	assert.Equal(t, "c", DefaultRegisterMap()[len(l.gauges)-1].Unit, "Default map changed")
+	l.Close()
+}
+
+func TestMaxRegisters(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	data[TemperatureReg], data[TemperatureReg+1] = 0x01, 0xa4
+	m := &mockModbus{
+		readData:    data,
+		addressed:   true,
+		maxQuantity: 16,
+	}
+	l, err := New(m, "tester-max-registers", WithMaxRegisters(16))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.Equal(t, int32(3), m.reads.Load(), "Block not read in requests of 16 registers")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read from the first request")
+	assert.InDelta(t, 42, r.Values["temperature"], 0.0001, "Temperature not read from the last request")
+
+	for _, n := range []int{0, maxReadRegs + 1} {
+		_, err = New(m, "tester-max-registers-invalid", WithMaxRegisters(n))
+		assert.Error(t, err, "Invalid registers per read should be rejected")
+	}
+}
+
+func TestInputRegisters(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-input-registers", WithInputRegisters())
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read from the input registers")
+	assert.Equal(t, int32(1), m.inputReads.Load(), "Input registers not read")
+	assert.Equal(t, int32(0), m.reads.Load(), "Holding registers read")
+}
+
+func TestNetEnergy(t *testing.T) {
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint32(data[ActiveEnergyReg:], 10000)
+	binary.BigEndian.PutUint32(data[ReactiveEnergyReg:], 3000)
+	m := &mockModbus{readData: data}
+	registers := RegisterMap{
+		{Channel: ImportEnergyChannel, Name: "mains_active_energy_import", Unit: "kwh", Help: "Imported energy", Offset: ActiveEnergyReg, Scale: 100, Value: get32BitEnergy, Size: 2, Cumulative: true, Hold: true},
+		{Channel: ExportEnergyChannel, Name: "mains_active_energy_export", Unit: "kwh", Help: "Exported energy", Offset: ReactiveEnergyReg, Scale: 100, Value: get32BitEnergy, Size: 2, Cumulative: true, Hold: true},
+	}
+	l, err := New(m, "tester-net-energy", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.InDelta(t, 70, metricValue(t, l.netEnergy.gauge), 0.0001, "Net energy not import minus export")
+
+	for _, g := range l.gauges {
+		// Let the energy filter allow for an hour of export
+		g.filter.prevChange = g.filter.prevChange.Add(-time.Hour)
+	}
+	binary.BigEndian.PutUint32(data[ReactiveEnergyReg:], 4000)
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.InDelta(t, 60, metricValue(t, l.netEnergy.gauge), 0.0001, "Net energy should decrease while exporting")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.InDelta(t, 60, metricValue(t, l.netEnergy.gauge), 0.0001, "Net energy of held inputs not held")
+
+	without, err := New(m, "tester-net-energy-without", WithRegisterMap(registers[:1]))
+	assert.NoError(t, err, "Could not create logger")
+	defer without.Close()
+	assert.Nil(t, without.netEnergy, "Net energy without an export channel")
+}
+
+func TestPowerFactorCheck(t *testing.T) {
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint16(data[ActivePowerReg:], 950)
+	binary.BigEndian.PutUint16(data[ApparentPowerReg:], 1000)
+	binary.BigEndian.PutUint16(data[PowerFactorReg:], 950)
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-pf-check")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.InDelta(t, 0.95, metricValue(t, l.pfCheck.computed), 0.0001, "Power factor not computed")
+	assert.Equal(t, 0.0, metricValue(t, l.pfCheck.warnings), "Consistent power factor counted")
+
+	binary.BigEndian.PutUint16(data[ApparentPowerReg:], 2000)
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.InDelta(t, 0.475, metricValue(t, l.pfCheck.computed), 0.0001, "Power factor not computed")
+	assert.Equal(t, 1.0, metricValue(t, l.pfCheck.warnings), "Inconsistent power factor not counted")
+
+	binary.BigEndian.PutUint16(data[ApparentPowerReg:], 0)
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.True(t, math.IsNaN(metricValue(t, l.pfCheck.computed)), "Power factor computed without apparent power")
+
+	registers, err := DefaultRegisterMap().Without("apparent_power")
+	assert.NoError(t, err, "Could not disable channel")
+	without, err := New(m, "tester-pf-check-without", WithRegisterMap(registers))
+	assert.NoError(t, err, "Could not create logger")
+	defer without.Close()
+	assert.Nil(t, without.pfCheck, "Power factor computed without apparent power channel")
+}
+
+func TestNegativeTemperature(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[TemperatureReg], data[TemperatureReg+1] = 0xff, 0xff
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-negative-temperature")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.InDelta(t, -0.1, r.Values["temperature"], 0.0001, "Temperature not signed tenths of a degree")
+}
+
+func TestPreReadWrite(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
 	}
-	l, err := New(m, "tester-2")
+	l, err := New(m, "tester-pre-read", WithPreReadWrite(0x60, 1))
 	assert.NoError(t, err, "Could not create logger")
-	err = l.update()
-	assert.Error(t, err, "Error expected from update")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	assert.Equal(t, []registerWrite{{0x60, 1}, {0x60, 1}}, m.singleWrites, "Trigger not written before every read")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Failed write should fail the update")
+	assert.Equal(t, 1.0, metricValue(t, l.errorClass.WithLabelValues(classRead)), "Failed write not counted")
 	l.Close()
 }
 
-func TestReadInvalidLength(t *testing.T) {
+func TestWriteRegister(t *testing.T) {
 	m := &mockModbus{
-		readData: make([]byte, 1),
+		readData: []byte{0x00, 0xc8},
 	}
-	l, err := New(m, "tester-3")
+	l, err := New(m, "tester-write-register")
 	assert.NoError(t, err, "Could not create logger")
-	err = l.update()
-	assert.Error(t, err, "Error expected from update")
+	assert.NoError(t, l.WriteRegister(0x80, 200), "Unexpected write error")
+	assert.Equal(t, []registerWrite{{0x80, 200}}, m.singleWrites, "Register not written")
+	assert.Error(t, l.WriteRegister(0x80, 100), "Read back mismatch should fail")
+	l.Close()
+}
+
+func TestMeterInfo(t *testing.T) {
+	m := &mockModbus{
+		readData: []byte{0x00, 0x01, 0xe2, 0x41},
+	}
+	l, err := New(m, "tester-info", WithSerialNumberName(0x40), WithMeterInfo(MeterInfo{SlaveID: "3", Transport: "rtu"}))
+	assert.NoError(t, err, "Could not create logger")
+	out := &dto.Metric{}
+	assert.NoError(t, l.meterInfo.Write(out), "Could not write metric")
+	labels := map[string]string{}
+	for _, p := range out.Label {
+		labels[p.GetName()] = p.GetValue()
+	}
+	assert.Equal(t, map[string]string{
+		"device_name": "123457",
+		"model":       Model,
+		"serial":      "123457",
+		"firmware":    "",
+		"ct_ratio":    "",
+		"slave_id":    "3",
+		"transport":   "rtu",
+	}, labels, "Unexpected meter info labels")
+	assert.Equal(t, 1.0, out.Gauge.GetValue(), "Info metric should be 1")
+	l.Close()
+
+	l, err = New(m, "tester-info-model", WithMeterInfo(MeterInfo{Model: "Other"}))
+	assert.NoError(t, err, "Could not create logger")
+	out = &dto.Metric{}
+	assert.NoError(t, l.meterInfo.Write(out), "Could not write metric")
+	for _, p := range out.Label {
+		if p.GetName() == "model" {
+			assert.Equal(t, "Other", p.GetValue(), "Model not overridden")
+		}
+	}
+	l.Close()
+
+	m = &mockModbus{readData: []byte{0x00, 0xc8}}
+	l, err = New(m, "tester-info-registers", WithFirmwareRegister(0x10), WithCTRatioRegister(0x100))
+	assert.NoError(t, err, "Could not create logger")
+	out = &dto.Metric{}
+	assert.NoError(t, l.meterInfo.Write(out), "Could not write metric")
+	labels = map[string]string{}
+	for _, p := range out.Label {
+		labels[p.GetName()] = p.GetValue()
+	}
+	assert.Equal(t, "200", labels["firmware"], "Firmware version not read")
+	assert.Equal(t, "200", labels["ct_ratio"], "CT ratio not read")
+	l.Close()
+}
+
+func TestModelRegisterMap(t *testing.T) {
+	assert.Contains(t, Models(), "d113003", "Default model not listed")
+	name, registers, err := ModelRegisterMap("d113003")
+	assert.NoError(t, err, "Could not get the default model")
+	assert.Equal(t, Model, name, "Unexpected model name")
+	assert.Len(t, registers, len(DefaultRegisterMap()), "Unexpected register map")
+	for _, key := range Models() {
+		_, registers, err := ModelRegisterMap(key)
+		assert.NoError(t, err, "Could not get model %v", key)
+		assert.NoError(t, registers.validate(), "Invalid register map of model %v", key)
+	}
+
+	_, _, err = ModelRegisterMap("unknown")
+	assert.Error(t, err, "Unknown model should be rejected")
+}
+
+func TestProbe(t *testing.T) {
+	data := make([]byte, readSize*2)
+	m := &mockModbus{
+		readData: data,
+	}
+	_, err := Probe(m)
+	assert.Error(t, err, "Error expected for zero frequency")
+
+	data[FrequencyReg], data[FrequencyReg+1] = 0x01, 0xf4
+	model, err := Probe(m)
+	assert.NoError(t, err, "Could not probe meter")
+	assert.Equal(t, Model, model, "Unexpected model")
+
+	_, err = Probe(m, WithInputRegisters())
+	assert.NoError(t, err, "Could not probe meter through the input registers")
+	assert.Equal(t, int32(1), m.inputReads.Load(), "Input registers not probed")
+
+	m.readData = make([]byte, 1)
+	_, err = Probe(m)
+	assert.Error(t, err, "Error expected for invalid length")
+
+	gateway := &mockModbus{readData: data, addressed: true, maxQuantity: 16}
+	_, err = Probe(gateway)
+	assert.Error(t, err, "Error expected for a block larger than the gateway allows")
+	model, err = Probe(gateway, WithMaxRegisters(16))
+	assert.NoError(t, err, "Could not probe meter in chunks")
+	assert.Equal(t, Model, model, "Unexpected model")
+	assert.Equal(t, int32(4), gateway.reads.Load(), "Block not read in chunks of 16 registers")
+
+	_, err = Probe(gateway, WithMaxRegisters(0))
+	assert.Error(t, err, "Error expected for invalid registers per read")
+}
+
+func TestNominalVoltage(t *testing.T) {
+	data := make([]byte, readSize*2)
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-nominal", WithNominalVoltage(120))
+	assert.NoError(t, err, "Could not create logger")
+	voltage := l.gauges[0]
+
+	data[VoltageReg], data[VoltageReg+1] = 0x04, 0xb0 // 120.0V
+	assert.NoError(t, l.update(), "No update error expected")
+	assert.InDelta(t, 120, metricValue(t, voltage), 0.0001, "Voltage not set")
+
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc // 230.0V
+	assert.NoError(t, l.update(), "No update error expected")
+	assert.InDelta(t, 120, metricValue(t, voltage), 0.0001, "Out of range voltage not discarded")
+	l.Close()
+
+	_, err = New(m, "tester-nominal-invalid", WithNominalVoltage(0))
+	assert.Error(t, err, "Error expected for invalid nominal voltage")
+}
+
+func TestRangeViolations(t *testing.T) {
+	data := make([]byte, readSize*2)
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-range")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	frequency := l.gauges[2]
+	violations := l.rangeViolations.WithLabelValues("frequency")
+
+	data[FrequencyReg], data[FrequencyReg+1] = 0x01, 0xf4 // 50.0Hz
+	assert.NoError(t, l.update(), "No update error expected")
+	assert.InDelta(t, 50, metricValue(t, frequency), 0.0001, "Frequency not set")
+	assert.Equal(t, 0.0, metricValue(t, violations), "Unexpected range violation")
+
+	data[FrequencyReg], data[FrequencyReg+1] = 0x03, 0x84 // 90.0Hz
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "No update error expected")
+	assert.InDelta(t, 50, metricValue(t, frequency), 0.0001, "Out of range frequency not discarded")
+	assert.NotContains(t, r.Values, "frequency", "Out of range frequency in the reading")
+	assert.Equal(t, 1.0, metricValue(t, violations), "Range violation not counted")
+
+	registers := append(DefaultRegisterMap(), Measurement{Channel: "bounded", Name: "mains_bounded", Scale: 1, Value: get16BitValue, Min: 2, Max: 1})
+	_, err = New(m, "tester-range-invalid", WithRegisterMap(registers))
+	assert.Error(t, err, "Minimum above the maximum should be rejected")
+}
+
+func TestVoltageEvents(t *testing.T) {
+	e := newVoltageEvents(map[string]string{"device_name": "tester-events"}, 207, 253)
+	for _, v := range []float64{230, 200, 190, 230, 200, 260, 255, 230} {
+		e.update(v)
+	}
+	assert.Equal(t, 2.0, metricValue(t, e.sags), "Sags not counted once per event")
+	assert.Equal(t, 1.0, metricValue(t, e.swells), "Swells not counted once per event")
+
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x07, 0x6c
+	l, err := New(&mockModbus{readData: data}, "tester-events-range")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Could not read once")
+	assert.NotContains(t, r.Values, "voltage", "Out of range voltage not discarded")
+	assert.Equal(t, 1.0, metricValue(t, l.voltageEvents.sags), "Sag outside of the voltage band not counted")
+}
+
+func TestAveragePower(t *testing.T) {
+	a := newAveragePower(map[string]string{"device_name": "tester-average"}, []time.Duration{15 * time.Minute, time.Hour})
+	start := time.Now()
+	for i := 0; i <= 8; i++ {
+		// 1 kWh every 7.5 minutes is 8 kW
+		a.update(start.Add(time.Duration(i)*450*time.Second), float64(i))
+	}
+	assert.InDelta(t, 8000, metricValue(t, a.gauge.WithLabelValues("15m")), 0.001, "Unexpected 15 minute average")
+	assert.InDelta(t, 8000, metricValue(t, a.gauge.WithLabelValues("1h")), 0.001, "Unexpected hourly average")
+	assert.LessOrEqual(t, len(a.samples), 9, "Samples not trimmed")
+
+	a.update(start.Add(75*time.Minute), 9)
+	assert.InDelta(t, 4000, metricValue(t, a.gauge.WithLabelValues("15m")), 0.001, "Unexpected 15 minute average")
+	assert.InDelta(t, 7000, metricValue(t, a.gauge.WithLabelValues("1h")), 0.001, "Unexpected hourly average")
+	assert.Equal(t, "1h30m", windowLabel(90*time.Minute), "Unexpected window label")
+	assert.Equal(t, "30s", windowLabel(30*time.Second), "Unexpected window label")
+}
+
+func TestReadOnce(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-once")
+	assert.NoError(t, err, "Could not create logger")
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Could not read once")
+	assert.Equal(t, "tester-once", r.Device, "Unexpected device")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not read")
+
+	m.err = errors.New("error")
+	_, err = l.ReadOnce()
+	assert.Error(t, err, "Error expected from read")
+	l.Close()
+}
+
+func TestReadDuration(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-read-duration")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.NoError(t, l.update(), "Unexpected update error")
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+
+	out := &dto.Metric{}
+	assert.NoError(t, l.readDuration.Write(out), "Could not write metric")
+	assert.Equal(t, uint64(2), out.Histogram.GetSampleCount(), "Reads not observed")
+	assert.Len(t, out.Histogram.Bucket, len(readDurationBuckets), "Unexpected buckets")
+	assert.Equal(t, 5.0, out.Histogram.Bucket[len(readDurationBuckets)-1].GetUpperBound(), "Buckets do not end at the default timeout")
+
+	l2, err := New(m, "tester-read-duration-timeout", WithReadTimeout(time.Second))
+	assert.NoError(t, err, "Could not create logger")
+	defer l2.Close()
+	out = &dto.Metric{}
+	assert.NoError(t, l2.readDuration.Write(out), "Could not write metric")
+	assert.Equal(t, 1.0, out.Histogram.Bucket[len(readDurationBuckets)-1].GetUpperBound(), "Buckets do not end at the timeout")
+	assert.Equal(t, 0.01, out.Histogram.Bucket[0].GetUpperBound(), "Unexpected first bucket")
+
+	_, err = New(m, "tester-read-duration-zero", WithReadTimeout(0))
+	assert.Error(t, err, "Read timeout of 0 should be rejected")
+}
+
+func TestSnapshot(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	m := &mockModbus{
+		readData: data,
+	}
+	l, err := New(m, "tester-snapshot")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	assert.True(t, l.Snapshot().Time.IsZero(), "Snapshot before the first read")
+
+	assert.NoError(t, l.update(), "Unexpected update error")
+	r := l.Snapshot()
+	assert.Equal(t, "tester-snapshot", r.Device, "Unexpected device")
+	assert.False(t, r.Time.IsZero(), "Snapshot has no read time")
+	assert.InDelta(t, 230, r.Values["voltage"], 0.0001, "Voltage not in snapshot")
+
+	r.Values["voltage"] = 0
+	assert.InDelta(t, 230, l.Snapshot().Values["voltage"], 0.0001, "Snapshot shares its values")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.InDelta(t, 230, l.Snapshot().Values["voltage"], 0.0001, "Snapshot changed by a failed read")
+}
+
+func TestLineProtocol(t *testing.T) {
+	r := Reading{
+		Device: "flat power",
+		Time:   time.Unix(1, 5),
+		Values: map[string]float64{"voltage": 230.1, "current": 2},
+	}
+	assert.Equal(t, `mains,device_name=flat\ power current=2,voltage=230.1 1000000005`, r.LineProtocol())
+
+	r.Values["frequency"] = math.NaN()
+	assert.Equal(t, `mains,device_name=flat\ power current=2,voltage=230.1 1000000005`, r.LineProtocol(), "NaN value not left out")
+	r.Values = map[string]float64{"frequency": math.NaN()}
+	assert.Empty(t, r.LineProtocol(), "Line without fields")
+	r.Values = map[string]float64{}
+	assert.Empty(t, r.LineProtocol(), "Line without fields")
+}
+
+func TestGrafanaLive(t *testing.T) {
+	pushed := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/live/push/power", r.URL.Path, "Unexpected push path")
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"), "Unexpected token")
+		body, _ := io.ReadAll(r.Body)
+		pushed <- string(body)
+	}))
+	defer srv.Close()
+
+	data := make([]byte, readSize*2)
+	data[VoltageReg], data[VoltageReg+1] = 0x08, 0xfc
+	g := NewGrafanaLive(srv.URL+"/", "secret", "power")
+	l, err := New(&mockModbus{readData: data}, "tester-grafana", WithSink(g))
+	assert.NoError(t, err, "Could not create logger")
+	assert.NoError(t, l.update(), "No update error expected")
+	g.Close()
+	assert.Contains(t, <-pushed, "mains,device_name=tester-grafana ", "Reading not pushed")
 	l.Close()
 }
 
+func TestJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.jsonl")
+	j, err := NewJSONLines(path, 100, 0)
+	assert.NoError(t, err, "Could not create sink")
+	r := Reading{Device: "tester-jsonl", Time: time.Unix(0, 0).UTC(), Values: map[string]float64{"voltage": 230}}
+	assert.NoError(t, j.Write(r), "Could not write reading")
+	assert.NoError(t, j.Write(r), "Could not write reading")
+	assert.NoError(t, j.Close(), "Could not close sink")
+
+	line := `{"device":"tester-jsonl","time":"1970-01-01T00:00:00Z","values":{"voltage":230}}` + "\n"
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err, "Could not read file")
+	assert.Equal(t, line, string(content), "Second reading should be in a new file")
+	rotated, err := filepath.Glob(path + ".*")
+	assert.NoError(t, err, "Could not find rotated file")
+	assert.Len(t, rotated, 1, "File not rotated")
+}
+
+func TestCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	c, err := NewCSV(path, []string{"voltage", "current"}, 0, 0)
+	assert.NoError(t, err, "Could not create sink")
+	r := Reading{Device: "tester-csv", Time: time.Unix(0, 0).UTC(), Values: map[string]float64{"voltage": 230.5}}
+	assert.NoError(t, c.Write(r), "Could not write reading")
+	assert.NoError(t, c.Close(), "Could not close sink")
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err, "Could not read file")
+	assert.Equal(t, "time,device,voltage,current\n1970-01-01T00:00:00Z,tester-csv,230.5,\n", string(content), "Unexpected CSV")
+
+	c, err = NewCSV(path, []string{"voltage", "current"}, 0, 0)
+	assert.NoError(t, err, "Could not reopen sink")
+	assert.NoError(t, c.Write(r), "Could not write reading")
+	assert.NoError(t, c.Close(), "Could not close sink")
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err, "Could not read file")
+	assert.Equal(t, 1, strings.Count(string(content), "time,device"), "Header repeated when appending")
+	assert.Equal(t, []string{"voltage", "current"}, RegisterMap{{Channel: "voltage"}, {Channel: "current"}}.Channels(), "Unexpected channels")
+}
+
 func TestGet16BitValue(t *testing.T) {
 	v := get16BitValue([]byte{0x1, 0x10}, 0, 1)
 	assert.InDelta(t, 272, v, 0.0001, "Value could not be extracted")
@@ -52,6 +1347,48 @@ func TestGet16BitValue(t *testing.T) {
 	assert.InDelta(t, 2.72, v, 0.0001, "Value could not be extracted")
 }
 
+func TestGet16BitSignedValue(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		{name: "Zero", data: []byte{0x00, 0x00}, want: 0},
+		{name: "Largest positive", data: []byte{0x7f, 0xff}, want: 32767},
+		{name: "Smallest negative", data: []byte{0x80, 0x00}, want: -32768},
+		{name: "Minus one", data: []byte{0xff, 0xff}, want: -1},
+		{name: "Export", data: []byte{0xfe, 0x0c}, want: -500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, get16BitSignedValue(tt.data, 0, 1), 0.0001, "Value could not be extracted")
+		})
+	}
+	v := get16BitSignedValue([]byte{0xfc, 0x18}, 0, 1000)
+	assert.InDelta(t, -1.0, v, 0.0001, "Scaled value could not be extracted")
+}
+
+func TestGetFloat32Value(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		value ValueFunc
+		scale float64
+		want  float64
+	}{
+		{name: "Big Endian", data: []byte{0x43, 0x66, 0x26, 0x66}, value: getFloat32Value, scale: 1, want: 230.15},
+		{name: "Word swapped", data: []byte{0x26, 0x66, 0x43, 0x66}, value: getFloat32SwappedValue, scale: 1, want: 230.15},
+		{name: "Negative", data: []byte{0xc2, 0x48, 0x00, 0x00}, value: getFloat32Value, scale: 1, want: -50},
+		{name: "Negative word swapped", data: []byte{0x00, 0x00, 0xc2, 0x48}, value: getFloat32SwappedValue, scale: 1, want: -50},
+		{name: "Scaled", data: []byte{0x44, 0x7a, 0x00, 0x00}, value: getFloat32Value, scale: 1000, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, tt.value(tt.data, 0, tt.scale), 0.0001, "Value could not be extracted")
+		})
+	}
+}
+
 func TestGet32BitEnergy(t *testing.T) {
 	v := get32BitEnergy([]byte{0x00, 0x1, 0x02, 0x10}, 0, 1)
 	assert.InDelta(t, 66064, v, 0.0001, "Value could not be extracted")
@@ -59,9 +1396,207 @@ func TestGet32BitEnergy(t *testing.T) {
 	assert.InDelta(t, 660.64, v, 0.0001, "Value could not be extracted")
 }
 
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	out := &dto.Metric{}
+	assert.NoError(t, m.Write(out), "Could not write metric")
+	switch {
+	case out.Gauge != nil:
+		return out.Gauge.GetValue()
+	case out.Counter != nil:
+		return out.Counter.GetValue()
+	}
+	t.Fatalf("Unsupported metric type: %v", out)
+	return 0
+}
+
+func TestClock(t *testing.T) {
+	m := &mockModbus{
+		readData: []byte{0, 0, 0, 0, 0x5e, 0x0b, 0xe1, 0x00},
+	}
+	l, err := New(m, "tester-clock")
+	assert.NoError(t, err, "Could not create logger")
+	c, err := l.Clock()
+	assert.NoError(t, err, "Could not read clock")
+	assert.Equal(t, time.Unix(1577836800, 0), c, "Clock could not be extracted")
+	err = l.SetClock(time.Unix(1577836801, 0))
+	assert.NoError(t, err, "Could not set clock")
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x5e, 0x0b, 0xe1, 0x01}, m.written, "Clock not written")
+
+	m.readData = make([]byte, 2)
+	_, err = l.Clock()
+	assert.Error(t, err, "Error expected from clock read")
+	l.Close()
+}
+
+func TestTariffEnergy(t *testing.T) {
+	data := make([]byte, readSize*2)
+	for i := 0; i <= tariffs; i++ {
+		binary.BigEndian.PutUint32(data[ActiveEnergyReg+4*i:], uint32(1000+i))
+	}
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-tariffs")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	r, err := l.ReadOnce()
+	assert.NoError(t, err, "Unexpected read error")
+	assert.Equal(t, 10.0, r.Values["active_energy"], "Total changed")
+	for i := 1; i <= tariffs; i++ {
+		assert.Equal(t, float64(1000+i)/100, r.Values[fmt.Sprintf("active_energy_tariff%d", i)], "Tariff %d not read", i)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err, "Could not gather metrics")
+	tariffLabels := []string{}
+	for _, f := range families {
+		if f.GetName() != "mains_active_energy_tariff_kwh" {
+			continue
+		}
+		assert.Equal(t, dto.MetricType_COUNTER, f.GetType(), "Tariff energy not a counter")
+		for _, metric := range f.Metric {
+			labels := map[string]string{}
+			for _, p := range metric.Label {
+				labels[p.GetName()] = p.GetValue()
+			}
+			if labels["device_name"] == "tester-tariffs" {
+				tariffLabels = append(tariffLabels, labels["tariff"])
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, tariffLabels, "Unexpected tariff labels")
+}
+
+func TestZeroAfter(t *testing.T) {
+	data := make([]byte, readSize*2)
+	data[CurrentReg+1] = 50
+	m := &mockModbus{readData: data}
+	l, err := New(m, "tester-zero-after", WithZeroAfter(3), WithRetryJitter(0))
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	current := l.gauges[1]
+	assert.NoError(t, l.update(), "Unexpected update error")
+
+	m.err = errors.New("error")
+	for i := 1; i < 3; i++ {
+		assert.Error(t, l.update(), "Error expected from update")
+		assert.Equal(t, 5.0, metricValue(t, current), "Value zeroed after %d failures", i)
+		assert.Equal(t, 1.0, metricValue(t, l.stale), "Data not marked stale")
+	}
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 0.0, metricValue(t, current), "Value not zeroed after 3 failures")
+
+	m.err = nil
+	assert.NoError(t, l.update(), "Unexpected update error")
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	assert.Equal(t, 5.0, metricValue(t, current), "Failures not reset by a successful read")
+
+	_, err = New(m, "tester-zero-after-0", WithZeroAfter(0))
+	assert.Error(t, err, "Zeroing after 0 failures should be rejected")
+}
+
+func TestDeviceClock(t *testing.T) {
+	m := &mockModbus{
+		readData: make([]byte, readSize*2),
+	}
+	l, err := New(m, "tester-device-clock")
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+	collected := func() map[string]float64 {
+		ch := make(chan prometheus.Metric, 2*len(l.gauges))
+		gaugeCollector{l: l}.Collect(ch)
+		close(ch)
+		values := map[string]float64{}
+		for metric := range ch {
+			values[metric.Desc().String()] = metricValue(t, metric)
+		}
+		return values
+	}
+	clockExported := func() (float64, bool) {
+		for desc, v := range collected() {
+			if strings.Contains(desc, `"mains_device_clock_timestamp_seconds"`) {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+
+	_, ok := clockExported()
+	assert.False(t, ok, "Clock exported before the first read")
+	assert.NoError(t, l.update(), "Unexpected update error")
+	_, ok = clockExported()
+	assert.False(t, ok, "Unset clock exported")
+	// All but the clock, with the apparent power also under its legacy name
+	assert.Len(t, collected(), len(l.gauges), "Other gauges left out")
+
+	copy(m.readData[TimeReg:], []byte{0, 0, 0, 0, 0x5e, 0x0b, 0xe1, 0x00})
+	assert.NoError(t, l.update(), "Unexpected update error")
+	v, ok := clockExported()
+	assert.True(t, ok, "Set clock not exported")
+	assert.Equal(t, 1577836800.0, v, "Clock could not be extracted")
+
+	m.err = errors.New("error")
+	assert.Error(t, l.update(), "Error expected from update")
+	_, ok = clockExported()
+	assert.False(t, ok, "Clock exported after a read error")
+}
+
+func TestSimulator(t *testing.T) {
+	s := NewSimulator()
+	now := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	l, err := New(s, "tester-simulator", WithStartupProbe())
+	assert.NoError(t, err, "Could not create logger")
+	defer l.Close()
+
+	first, err := l.ReadOnce()
+	assert.NoError(t, err, "Could not read the simulator")
+	assert.InDelta(t, 230, first.Values["voltage"], 5, "Implausible voltage")
+	assert.InDelta(t, 50, first.Values["frequency"], 0.1, "Implausible frequency")
+	assert.Greater(t, first.Values["current"], 0.0, "No current drawn")
+	assert.InDelta(t, 0.95, first.Values["power_factor"], 0.001, "Implausible power factor")
+	assert.NotContains(t, first.Values, "clock", "Unset clock read")
+
+	// Read the simulator directly, as the energy filter runs on the system clock
+	before, err := s.ReadHoldingRegisters(0, readSize)
+	assert.NoError(t, err, "Could not read the simulator")
+	now = now.Add(time.Hour)
+	after, err := s.ReadHoldingRegisters(0, readSize)
+	assert.NoError(t, err, "Could not read the simulator")
+	increase := get32BitEnergy(after, ActiveEnergyReg, 100) - get32BitEnergy(before, ActiveEnergyReg, 100)
+	assert.InDelta(t, get16BitSignedValue(after, ActivePowerReg, 1)/1000, increase, 0.5, "Energy not counted from the power")
+	assert.Less(t, simLoad(time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)), simLoad(now), "Night load not lower")
+
+	assert.NoError(t, l.SetClock(now.Add(-time.Minute)), "Could not set the clock")
+	c, err := l.Clock()
+	assert.NoError(t, err, "Could not read the clock")
+	assert.Equal(t, now.Add(-time.Minute).Unix(), c.Unix(), "Clock not set")
+
+	assert.NoError(t, l.WriteRegister(0x100, 200), "Could not write a setting")
+	_, err = s.ReadHoldingRegisters(0x200, 1)
+	assert.True(t, isIllegalAddress(err), "Unwritten register should be an illegal address")
+
+	input, err := s.ReadInputRegisters(0, readSize)
+	assert.NoError(t, err, "Could not read the input registers")
+	assert.InDelta(t, 50, get16BitValue(input, FrequencyReg, 10), 0.1, "Input registers do not hold the measurements")
+	_, err = s.ReadInputRegisters(0x100, 1)
+	assert.True(t, isIllegalAddress(err), "Input register outside of the block should be an illegal address")
+}
+
 type mockModbus struct {
-	readData []byte
-	err      error
+	readData     []byte
+	written      []byte
+	singleWrites []registerWrite
+	err          error
+	reads        atomic.Int32
+	inputReads   atomic.Int32
+	panicAfter   int32
+	block        chan struct{}
+	blocked      chan struct{}
+	addressed    bool            // Return only the requested registers of readData
+	failOnce     map[uint16]bool // Addresses failing on their first read
+	illegal      map[uint16]bool // Addresses failing with an illegal address exception
+	maxQuantity  uint16          // Larger reads fail with an illegal address exception, if set
 }
 
 func (m *mockModbus) ReadCoils(address, quantity uint16) (results []byte, err error) {
@@ -77,15 +1612,38 @@ func (m *mockModbus) WriteMultipleCoils(address, quantity uint16, value []byte)
 	return m.readData, m.err
 }
 func (m *mockModbus) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	m.inputReads.Add(1)
 	return m.readData, m.err
 }
 func (m *mockModbus) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	if m.reads.Add(1) == m.panicAfter {
+		panic("mock panic")
+	}
+	if m.block != nil {
+		select {
+		case m.blocked <- struct{}{}:
+		default:
+		}
+		<-m.block
+	}
+	if m.failOnce[address] {
+		delete(m.failOnce, address)
+		return nil, errors.New("chunk error")
+	}
+	if m.illegal[address] || (m.maxQuantity > 0 && quantity > m.maxQuantity) {
+		return nil, &modbus.ModbusError{FunctionCode: modbus.FuncCodeReadHoldingRegisters, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+	}
+	if m.addressed {
+		return m.readData[address*2 : (address+quantity)*2], m.err
+	}
 	return m.readData, m.err
 }
 func (m *mockModbus) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	m.singleWrites = append(m.singleWrites, registerWrite{address: address, value: value})
 	return m.readData, m.err
 }
 func (m *mockModbus) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	m.written = value
 	return m.readData, m.err
 }
 func (m *mockModbus) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
@@ -103,11 +1661,12 @@ func Test_energyFilter_filter(t *testing.T) {
 		name   string
 		filter *energyFilter
 		args   []float64
+		step   time.Duration // Between readings, the default poll rate if unset
 		want   float64
 	}{
 		{
 			name:   "Happy path",
-			filter: newEnergyFilter(100),
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
 			args: []float64{
 				10, 10.01, 10.02, 10.03,
 			},
@@ -115,7 +1674,7 @@ func Test_energyFilter_filter(t *testing.T) {
 		},
 		{
 			name:   "Disallow decreasing value",
-			filter: newEnergyFilter(100),
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
 			args: []float64{
 				10, 10.01, 9,
 			},
@@ -123,15 +1682,47 @@ func Test_energyFilter_filter(t *testing.T) {
 		},
 		{
 			name:   "Disallow zero value",
-			filter: newEnergyFilter(100),
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
 			args: []float64{
 				10, 10, 0,
 			},
 			want: 10,
 		},
+		{
+			name:   "Disallow spurious zero midstream",
+			filter: newEnergyFilter(100, avgVoltage, 0, true),
+			args: []float64{
+				10, 10.01, 0, 10.02,
+			},
+			want: 10.02,
+		},
+		{
+			name:   "Wait for non-zero baseline",
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
+			args: []float64{
+				0, 0, 1234, 1234.01,
+			},
+			want: 1234.01,
+		},
+		{
+			name:   "Genuine zero start",
+			filter: newEnergyFilter(100, avgVoltage, 0, true),
+			args: []float64{
+				0, 0, 0.01, 0.02,
+			},
+			want: 0.02,
+		},
+		{
+			name:   "Disallow large increase from zero start",
+			filter: newEnergyFilter(100, avgVoltage, 0, true),
+			args: []float64{
+				0, 20,
+			},
+			want: 0,
+		},
 		{
 			name:   "Disallow large increase",
-			filter: newEnergyFilter(100),
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
 			args: []float64{
 				10, 20,
 			},
@@ -139,20 +1730,90 @@ func Test_energyFilter_filter(t *testing.T) {
 		},
 		{
 			name:   "Allow occasional updates",
-			filter: newEnergyFilter(100),
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
 			args: []float64{
 				10, 10, 10, 10, 10, 10, 10, 10, 10.5,
 			},
 			want: 10.5,
 		},
+		{
+			name:   "Allow larger increases at a slow poll rate",
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
+			args: []float64{
+				10, 10.15, 10.3,
+			},
+			step: 30 * time.Second,
+			want: 10.3,
+		},
+		{
+			name:   "Disallow large increase at a slow poll rate",
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
+			args: []float64{
+				10, 10.2,
+			},
+			step: 30 * time.Second,
+			want: 10,
+		},
+		{
+			name:   "Disallow increase beyond the max current",
+			filter: newEnergyFilter(100, avgVoltage, 0, false),
+			args: []float64{
+				10, 10.2,
+			},
+			want: 10,
+		},
+		{
+			name:   "Allow increase within a higher max current",
+			filter: newEnergyFilter(400, avgVoltage, 0, false),
+			args: []float64{
+				10, 10.2,
+			},
+			want: 10.2,
+		},
+		{
+			name:   "Accept persistent jump after window",
+			filter: newEnergyFilter(100, avgVoltage, 3, false),
+			args: []float64{
+				10, 20, 20, 20, 20, 20.01,
+			},
+			want: 20.01,
+		},
+		{
+			name:   "Hold jump within window",
+			filter: newEnergyFilter(100, avgVoltage, 3, false),
+			args: []float64{
+				10, 20, 20, 20,
+			},
+			want: 10,
+		},
+		{
+			name:   "Discard alternating garbage after window",
+			filter: newEnergyFilter(100, avgVoltage, 3, false),
+			args: []float64{
+				10, 20, 0, 30, 5, 40, 0,
+			},
+			want: 10,
+		},
+		{
+			name:   "Restart window when the jump changes",
+			filter: newEnergyFilter(100, avgVoltage, 3, false),
+			args: []float64{
+				10, 20, 20, 20, 50, 50,
+			},
+			want: 10,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var got float64
+			step := tt.step
+			if step == 0 {
+				step = time.Second * pollRateSec
+			}
 			notNow := time.Time{}
 			for _, in := range tt.args {
 				got = tt.filter.filter(in, notNow)
-				notNow = notNow.Add(time.Second * pollRateSec)
+				notNow = notNow.Add(step)
 			}
 			if got != tt.want {
 				t.Errorf("energyFilter.filter() = %v, want %v", got, tt.want)
@@ -0,0 +1,38 @@
+package logger
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Channels of the imported and exported active energy. Register maps with
+// both also export the net energy drawn from the grid.
+const (
+	ImportEnergyChannel = "active_energy_import"
+	ExportEnergyChannel = "active_energy_export"
+)
+
+// netEnergy exports the imported minus the exported active energy. Unlike
+// its inputs it decreases while exporting, so it is a gauge.
+type netEnergy struct {
+	gauge  prometheus.Gauge
+	sticky bool // Kept on read errors, as both inputs are
+}
+
+func newNetEnergy(label map[string]string, sticky bool) *netEnergy {
+	return &netEnergy{
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "mains_net_energy_kwh",
+			Help:        "Mains active energy imported minus exported, decreasing while exporting",
+			ConstLabels: label,
+		}),
+		sticky: sticky,
+	}
+}
+
+// update sets the net energy from the filtered energies of a reading, keeping
+// the last value while either is unavailable
+func (n *netEnergy) update(values map[string]float64) {
+	imported, importOK := values[ImportEnergyChannel]
+	exported, exportOK := values[ExportEnergyChannel]
+	if importOK && exportOK {
+		n.gauge.Set(imported - exported)
+	}
+}
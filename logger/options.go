@@ -0,0 +1,225 @@
+package logger
+
+import "time"
+
+// Option configures optional Logger behaviour
+type Option func(*Logger)
+
+// WithPollRate sets the interval Poller reads the meter at, 10s by default.
+// The energy filter allows for the time that passed between readings, so its
+// limits do not depend on the poll rate.
+func WithPollRate(rate time.Duration) Option {
+	return func(l *Logger) {
+		l.pollRate = rate
+	}
+}
+
+// WithReconnect reports every poll to r, which reconnects the transport of
+// the client after several consecutive polls failed. Loggers sharing a bus
+// share its Reconnector.
+func WithReconnect(r *Reconnector) Option {
+	return func(l *Logger) {
+		l.reconnect = r
+	}
+}
+
+// WithAlignedPolls polls at multiples of the poll rate on the wall clock,
+// such as at :00, :10 and :20 for 10s, so the readings of several loggers line
+// up. By default polls are timed from the start of Poller.
+func WithAlignedPolls() Option {
+	return func(l *Logger) {
+		l.alignTicks = true
+	}
+}
+
+// WithReadTimeout tells the logger the timeout of the client's handler, 5s by
+// default, so the sensor_read_duration_seconds buckets span up to it
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(l *Logger) {
+		l.readTimeout = timeout
+	}
+}
+
+// WithMaxRegisters reads the register block in requests of at most n
+// registers, for gateways limiting the size of a Modbus response. By default
+// the block is read at once.
+func WithMaxRegisters(n int) Option {
+	return func(l *Logger) {
+		l.maxRegs = n
+	}
+}
+
+// WithRetryJitter sets the upper bound of the random delay before retrying a
+// read, 50ms by default
+func WithRetryJitter(jitter time.Duration) Option {
+	return func(l *Logger) {
+		l.retryJitter = jitter
+	}
+}
+
+// WithNominalVoltage sets the nominal mains voltage, such as 120, 230 or 400.
+// Voltage readings outside of the tolerance band around it are discarded.
+func WithNominalVoltage(v float64) Option {
+	return func(l *Logger) {
+		l.nominalVoltage = v
+	}
+}
+
+// WithVoltageEventThresholds sets the fractions of the nominal voltage below
+// which a sag and above which a swell is counted, 0.9 and 1.1 by default
+func WithVoltageEventThresholds(sag, swell float64) Option {
+	return func(l *Logger) {
+		l.sagThreshold = sag
+		l.swellThreshold = swell
+	}
+}
+
+// WithRawRegisters exports every raw register of the read block as a
+// mains_raw_register gauge, for debugging scale and byte order problems
+func WithRawRegisters() Option {
+	return func(l *Logger) {
+		l.exposeRaw = true
+	}
+}
+
+// WithTimestamps exports the measurements with the time of the read they
+// come from, rather than letting Prometheus use the scrape time
+func WithTimestamps() Option {
+	return func(l *Logger) {
+		l.timestamps = true
+	}
+}
+
+// WithStartupProbe makes New read the register block once and fail if the
+// meter does not answer with a complete block, rather than starting a logger
+// that only ever reports read errors
+func WithStartupProbe() Option {
+	return func(l *Logger) {
+		l.startupProbe = true
+	}
+}
+
+// WithInputRegisters reads the measurements from the input registers, with
+// Modbus function code 4, instead of the holding registers. The clock and
+// settings such as the CT ratio are still read from the holding registers.
+func WithInputRegisters() Option {
+	return func(l *Logger) {
+		l.inputRegisters = true
+	}
+}
+
+// WithZeroAfter keeps the last values of the measurements without Hold for up
+// to failures-1 consecutive failed reads, zeroing them on the next one. The
+// default of 1 zeroes them on the first failed read.
+func WithZeroAfter(failures int) Option {
+	return func(l *Logger) {
+		l.zeroAfter = failures
+	}
+}
+
+// WithEnergyFilterWindow accepts an energy reading after polls consecutive
+// readings were discarded by the energy filter, 0 by default to never do so
+func WithEnergyFilterWindow(polls int) Option {
+	return func(l *Logger) {
+		l.filterWindow = polls
+	}
+}
+
+// WithEnergyFilterMaxCurrent sets the current in A the energy filter allows
+// for, 100A by default. Energy increases faster than the current draws at the
+// nominal voltage are discarded. Measurements with their own MaxCurrent keep it.
+func WithEnergyFilterMaxCurrent(amps float64) Option {
+	return func(l *Logger) {
+		l.filterCurrent = amps
+	}
+}
+
+// WithEnergyZeroStart takes an energy reading of zero as the baseline of the
+// energy filter, for freshly reset meters. By default the filter waits for the
+// first non-zero reading, accepting whatever it is.
+func WithEnergyZeroStart() Option {
+	return func(l *Logger) {
+		l.zeroStart = true
+	}
+}
+
+// WithRegisterMap decodes and exports the measurements of registers instead
+// of DefaultRegisterMap, such as to change metric help texts or units
+func WithRegisterMap(registers RegisterMap) Option {
+	return func(l *Logger) {
+		l.registers = registers
+	}
+}
+
+// WithPreReadWrite writes value to the holding register at address before
+// every read, for meters that need a command to take a measurement
+func WithPreReadWrite(address, value uint16) Option {
+	return func(l *Logger) {
+		l.preReadWrite = &registerWrite{address: address, value: value}
+	}
+}
+
+// MeterInfo holds the model of a meter and how it is reached, for the
+// mains_meter_info metric
+type MeterInfo struct {
+	Model     string // Model if unset
+	SlaveID   string
+	Transport string
+}
+
+// WithMeterInfo adds info to the labels of the mains_meter_info metric
+func WithMeterInfo(info MeterInfo) Option {
+	return func(l *Logger) {
+		l.info = info
+	}
+}
+
+// WithReadOnScrape reads the meter when the gauges are scraped instead of
+// from Poller, which must then not be started. Scrapes within maxAge of the
+// last read reuse it. Scrapes wait for the read, so they take at least a
+// Modbus transaction.
+func WithReadOnScrape(maxAge time.Duration) Option {
+	return func(l *Logger) {
+		l.lazyMaxAge = maxAge
+	}
+}
+
+// WithAveragePower exports the average active power over each of the
+// rolling windows as mains_average_power_w, computed from the active energy
+func WithAveragePower(windows ...time.Duration) Option {
+	return func(l *Logger) {
+		l.averageWindows = windows
+	}
+}
+
+// WithSink passes every successful reading to s
+func WithSink(s Sink) Option {
+	return func(l *Logger) {
+		l.sinks = append(l.sinks, s)
+	}
+}
+
+// WithFirmwareRegister adds the firmware version held in the holding register
+// at address to the labels of the mains_meter_info metric
+func WithFirmwareRegister(address uint16) Option {
+	return func(l *Logger) {
+		l.firmwareReg = &address
+	}
+}
+
+// WithCTRatioRegister adds the CT ratio held in the holding register at
+// address to the labels of the mains_meter_info metric
+func WithCTRatioRegister(address uint16) Option {
+	return func(l *Logger) {
+		l.ctRatioReg = &address
+	}
+}
+
+// WithSerialNumberName names the device after the 32 bit serial number held
+// in the two holding registers at address, falling back to the given device
+// name if it cannot be read
+func WithSerialNumberName(address uint16) Option {
+	return func(l *Logger) {
+		l.serialNumberReg = &address
+	}
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reading holds the values decoded from one successful poll of a device
+type Reading struct {
+	Device string             `json:"device"`
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"` // Keyed by channel, such as "voltage"
+}
+
+var lineProtocolEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// LineProtocol formats the reading as an InfluxDB line protocol "mains"
+// measurement tagged with the device name. Values that are NaN or infinite
+// are left out, and a reading without any other values gives an empty
+// string, as a line needs at least one field.
+func (r Reading) LineProtocol() string {
+	channels := make([]string, 0, len(r.Values))
+	for c, v := range r.Values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			channels = append(channels, c)
+		}
+	}
+	if len(channels) == 0 {
+		return ""
+	}
+	sort.Strings(channels)
+
+	var b strings.Builder
+	b.WriteString("mains,device_name=")
+	b.WriteString(lineProtocolEscaper.Replace(r.Device))
+	for i, c := range channels {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(lineProtocolEscaper.Replace(c))
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(r.Values[c], 'f', -1, 64))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(r.Time.UnixNano(), 10))
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reconnectFailures   = 3               // Consecutive failed polls before reconnecting
+	maxReconnectBackoff = 5 * time.Minute // Upper bound of the delay between reconnects
+)
+
+// Connection is the transport under a Modbus client, such as a
+// modbus.RTUClientHandler or modbus.TCPClientHandler
+type Connection interface {
+	Connect() error
+	Close() error
+}
+
+// Reconnector reopens a connection after repeated failed polls, backing off
+// exponentially from the initial delay while the reconnects do not help. The
+// loggers of all slaves on a bus share its Reconnector, so a poll of any of
+// them succeeding shows the connection is fine.
+type Reconnector struct {
+	conn    Connection
+	name    string
+	initial time.Duration
+	count   prometheus.Counter
+
+	mu       sync.Mutex
+	failures int
+	backoff  time.Duration
+	next     time.Time // No reconnect before this time
+}
+
+// NewReconnector returns a Reconnector for conn, counting its reconnects in
+// count. The name identifies the connection in log messages.
+func NewReconnector(conn Connection, name string, initial time.Duration, count prometheus.Counter) *Reconnector {
+	return &Reconnector{
+		conn:    conn,
+		name:    name,
+		initial: initial,
+		backoff: initial,
+		count:   count,
+	}
+}
+
+// Reconnect closes and reopens the connection right away
+func (r *Reconnector) Reconnect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reconnect()
+}
+
+// reconnect closes and reopens the connection. r.mu must be held.
+func (r *Reconnector) reconnect() error {
+	if err := r.conn.Close(); err != nil {
+		log.Debugf("Could not close the connection of %v: %v", r.name, err)
+	}
+	if err := r.conn.Connect(); err != nil {
+		return err
+	}
+	r.count.Inc()
+	return nil
+}
+
+// failed records a failed poll and reconnects once there were enough of them
+// and the backoff has passed
+func (r *Reconnector) failed(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+	if r.failures < reconnectFailures || now.Before(r.next) {
+		return
+	}
+	wait := r.backoff
+	r.next = now.Add(wait)
+	r.backoff = min(2*r.backoff, maxReconnectBackoff)
+
+	log.Warnf("%d consecutive polls on %v failed, reconnecting", r.failures, r.name)
+	if err := r.reconnect(); err != nil {
+		log.Errorf("Could not reconnect %v, retrying in %v: %v", r.name, wait, err)
+	}
+}
+
+// succeeded records a successful poll, resetting the backoff
+func (r *Reconnector) succeeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = 0
+	r.backoff = r.initial
+	r.next = time.Time{}
+}
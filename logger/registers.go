@@ -0,0 +1,309 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ValueFunc decodes the value at the byte offset of a read block
+type ValueFunc func(data []byte, offset int, scale float64) float64
+
+// Measurement describes one value in the read block and the gauge it is
+// exported as
+type Measurement struct {
+	Channel string // Name of the value in readings, such as "voltage"
+	Name    string // Metric name without the unit suffix
+	Unit    string // Unit suffix of the metric name, such as "v"
+	Help    string // Metric help text
+	// Labels are added to the device_name label. Measurements exported under
+	// the same metric name need the same label names.
+	Labels map[string]string
+	Offset int       // Byte offset into the read block
+	Scale  float64   // The raw value is divided by the scale
+	Value  ValueFunc // Decodes the raw value
+	Size   int       // Number of registers the value spans, 1 if unset
+	// Cumulative marks an energy total, which is exported as a counter and
+	// gets its own energy filter discarding implausible changes
+	Cumulative bool
+	// Hold keeps the last value on read errors instead of zeroing it
+	Hold bool
+	// MaxCurrent is the current the energy filter allows for, 0 for the
+	// one set with WithEnergyFilterMaxCurrent
+	MaxCurrent float64
+	// Min and Max bound the plausible decoded values, readings outside of
+	// them are discarded. Unbounded unless Max is above Min. The voltage
+	// defaults to the band around the nominal voltage.
+	Min float64
+	Max float64
+	// OmitZero leaves the gauge out of scrapes while its value is zero or
+	// missing, for values where zero means unset such as the device clock
+	OmitZero bool
+	// LegacyName is a former metric name without the unit suffix, under
+	// which the value is also exported so existing queries keep working
+	LegacyName string
+}
+
+// end returns the byte offset following the value
+func (m Measurement) end() int {
+	return m.Offset + max(m.Size, 1)*2
+}
+
+// metricName returns the full metric name including the unit suffix
+func (m Measurement) metricName() string {
+	return withUnit(m.Name, m.Unit)
+}
+
+// legacyMetricName returns the full legacy metric name, empty if unset
+func (m Measurement) legacyMetricName() string {
+	if m.LegacyName == "" {
+		return ""
+	}
+	return withUnit(m.LegacyName, m.Unit)
+}
+
+func withUnit(name, unit string) string {
+	if unit == "" {
+		return name
+	}
+	return name + "_" + unit
+}
+
+// RegisterMap lists the measurements decoded from a meter
+type RegisterMap []Measurement
+
+// DefaultRegisterMap returns the measurements of the YTL-e D113003. The map
+// is a fresh copy that may be changed before passing it to WithRegisterMap.
+func DefaultRegisterMap() RegisterMap {
+	registers := RegisterMap{
+		{Channel: "voltage", Name: "mains_voltage", Unit: "v", Help: "Mains voltage", Offset: VoltageReg, Scale: 10, Value: get16BitValue},
+		{Channel: "current", Name: "mains_current", Unit: "a", Help: "Mains current", Offset: CurrentReg, Scale: 10, Value: get16BitValue},
+		{Channel: "frequency", Name: "mains_frequency", Unit: "hz", Help: "Mains frequency", Offset: FrequencyReg, Scale: 10, Value: get16BitValue, Min: 45, Max: 65},
+		{Channel: "active_power", Name: "mains_active_power", Unit: "w", Help: "Mains active power", Offset: ActivePowerReg, Scale: 1, Value: get16BitSignedValue},
+		{Channel: "reactive_power", Name: "mains_reactive_power", Unit: "var", Help: "Mains reactive power", Offset: ReactivePowerReg, Scale: 1, Value: get16BitSignedValue},
+		{Channel: "apparent_power", Name: "mains_apparent_power", Unit: "va", Help: "Mains apparent power", Offset: ApparentPowerReg, Scale: 1, Value: get16BitValue, LegacyName: "mains_appartent_power"},
+		{Channel: "power_factor", Name: "mains_power_factor", Unit: "pf", Help: "Mains power factor", Offset: PowerFactorReg, Scale: 1000, Value: get16BitSignedValue, Min: -1 - pfTolerance, Max: 1 + pfTolerance},
+		{Channel: "active_energy", Name: "mains_active_energy", Unit: "kwh", Help: "Mains active energy", Offset: ActiveEnergyReg, Scale: 100, Value: get32BitEnergy, Size: 2, Cumulative: true, Hold: true},
+		{Channel: "reactive_energy", Name: "mains_reactive_energy", Unit: "kvarh", Help: "Mains reactive energy", Offset: ReactiveEnergyReg, Scale: 100, Value: get32BitEnergy, Size: 2, Cumulative: true, Hold: true},
+	}
+	registers = append(registers, tariffEnergy("active_energy", "mains_active_energy_tariff", "kwh", "Mains active energy by tariff", ActiveEnergyReg)...)
+	registers = append(registers, tariffEnergy("reactive_energy", "mains_reactive_energy_tariff", "kvarh", "Mains reactive energy by tariff", ReactiveEnergyReg)...)
+	return append(registers, RegisterMap{
+		{Channel: "clock", Name: "mains_device_clock_timestamp", Unit: "seconds", Help: "Device real time clock since the Unix epoch", Offset: TimeReg, Scale: 1, Value: get64BitValue, Size: clockSize, OmitZero: true},
+		{Channel: "temperature", Name: "mains_device_temperature", Unit: "c", Help: "Mains device temperature", Offset: TemperatureReg, Scale: 10, Value: get16BitSignedValue},
+	}...)
+}
+
+// tariffEnergy returns the measurements of the energy bins following the
+// total at offset, one for each tariff time slot. The channels are the
+// channel of the total followed by "_tariff" and the tariff number, such as
+// "active_energy_tariff1".
+func tariffEnergy(channel, name, unit, help string, offset int) []Measurement {
+	m := make([]Measurement, 0, tariffs)
+	for t := 1; t <= tariffs; t++ {
+		m = append(m, Measurement{
+			Channel:    fmt.Sprintf("%v_tariff%d", channel, t),
+			Name:       name,
+			Unit:       unit,
+			Help:       help,
+			Labels:     map[string]string{"tariff": strconv.Itoa(t)},
+			Offset:     offset + 4*t,
+			Scale:      100,
+			Value:      get32BitEnergy,
+			Size:       2,
+			Cumulative: true,
+			Hold:       true,
+		})
+	}
+	return m
+}
+
+// meterModel is a built-in register map, selected by its key in meterModels
+type meterModel struct {
+	name      string // Full model name, for the mains_meter_info model label
+	registers func() RegisterMap
+}
+
+var meterModels = map[string]meterModel{
+	"d113003": {name: Model, registers: DefaultRegisterMap},
+}
+
+// Models returns the keys of the built-in register maps, sorted
+func Models() []string {
+	keys := make([]string, 0, len(meterModels))
+	for k := range meterModels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ModelRegisterMap returns the full model name and a fresh copy of the
+// built-in register map of the model with the given key, see Models
+func ModelRegisterMap(key string) (string, RegisterMap, error) {
+	m, ok := meterModels[key]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown meter model %q", key)
+	}
+	return m.name, m.registers(), nil
+}
+
+// Channels returns the channels of the measurements in map order
+func (r RegisterMap) Channels() []string {
+	channels := make([]string, len(r))
+	for i, m := range r {
+		channels[i] = m.Channel
+	}
+	return channels
+}
+
+// Fahrenheit returns a copy of the map with the measurements in degrees
+// Celsius converted to degrees Fahrenheit, with the unit suffix "f"
+func (r RegisterMap) Fahrenheit() RegisterMap {
+	out := make(RegisterMap, len(r))
+	copy(out, r)
+	for i, m := range out {
+		if m.Unit != "c" {
+			continue
+		}
+		celsius := m.Value
+		out[i].Unit = "f"
+		out[i].Value = func(data []byte, offset int, scale float64) float64 {
+			return celsius(data, offset, scale)*9/5 + 32
+		}
+	}
+	return out
+}
+
+// Scaled returns a copy of the map with the scales of the given channels
+// replaced, failing for channels that are not in the map
+func (r RegisterMap) Scaled(scales map[string]float64) (RegisterMap, error) {
+	out := make(RegisterMap, len(r))
+	copy(out, r)
+	for channel, scale := range scales {
+		found := false
+		for i := range out {
+			if out[i].Channel == channel {
+				out[i].Scale = scale
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown channel %q", channel)
+		}
+	}
+	return out, nil
+}
+
+// Kilo returns a copy of the map with a kW, kvar and kVA measurement added
+// for every power measurement in W, var and VA. The added channels have a
+// "_k" suffix, such as "active_power_k", and no legacy metric name.
+func (r RegisterMap) Kilo() RegisterMap {
+	out := make(RegisterMap, len(r), 2*len(r))
+	copy(out, r)
+	for _, m := range r {
+		switch m.Unit {
+		case "w", "var", "va":
+			m.Channel += "_k"
+			m.Unit = "k" + m.Unit
+			m.Scale *= 1000
+			m.LegacyName = ""
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// WithoutLegacyNames returns a copy of the map exporting every measurement
+// only under its current metric name
+func (r RegisterMap) WithoutLegacyNames() RegisterMap {
+	out := make(RegisterMap, len(r))
+	copy(out, r)
+	for i := range out {
+		out[i].LegacyName = ""
+	}
+	return out
+}
+
+// Holding returns a copy of the map where exactly the given channels keep
+// their last value on read errors, failing for channels that are not in the map
+func (r RegisterMap) Holding(channels ...string) (RegisterMap, error) {
+	hold := map[string]bool{}
+	for _, c := range channels {
+		hold[c] = true
+	}
+	out := make(RegisterMap, len(r))
+	for i, m := range r {
+		m.Hold = hold[m.Channel]
+		delete(hold, m.Channel)
+		out[i] = m
+	}
+	for c := range hold {
+		return nil, fmt.Errorf("unknown channel %q", c)
+	}
+	return out, nil
+}
+
+// Without returns a copy of the map without the given channels, failing for
+// channels that are not in the map
+func (r RegisterMap) Without(channels ...string) (RegisterMap, error) {
+	out := make(RegisterMap, 0, len(r))
+	disabled := map[string]bool{}
+	for _, c := range channels {
+		disabled[c] = true
+	}
+	for _, m := range r {
+		if disabled[m.Channel] {
+			delete(disabled, m.Channel)
+			continue
+		}
+		out = append(out, m)
+	}
+	for c := range disabled {
+		return nil, fmt.Errorf("unknown channel %q", c)
+	}
+	return out, nil
+}
+
+// blockSize returns the number of registers to read, which is shorter than
+// the full block if the measurements at its end are left out
+func (r RegisterMap) blockSize() uint16 {
+	end := 0
+	for _, m := range r {
+		end = max(end, m.end())
+	}
+	if end > TemperatureReg {
+		return readSize
+	}
+	return uint16(end / 2)
+}
+
+func (r RegisterMap) validate() error {
+	if len(r) == 0 {
+		return errors.New("no measurements")
+	}
+	channels := map[string]bool{}
+	for _, m := range r {
+		if m.Channel == "" || m.Name == "" {
+			return fmt.Errorf("measurement at offset %d has no channel or name", m.Offset)
+		}
+		if channels[m.Channel] {
+			return fmt.Errorf("duplicate channel %v", m.Channel)
+		}
+		channels[m.Channel] = true
+		if m.Value == nil || m.Scale == 0 {
+			return fmt.Errorf("channel %v has no value function or scale", m.Channel)
+		}
+		if m.MaxCurrent < 0 {
+			return fmt.Errorf("channel %v has a negative maximum current", m.Channel)
+		}
+		if m.Min > m.Max {
+			return fmt.Errorf("channel %v has a minimum above its maximum", m.Channel)
+		}
+		if m.Offset < 0 || m.end() > readSize*2 {
+			return fmt.Errorf("channel %v offset %d is outside of the read block", m.Channel, m.Offset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// Simulator is a modbus.Client answering like the meter decoded by
+// DefaultRegisterMap, for running without hardware. The voltage varies
+// slowly around 230V, the current follows a daily load curve and the energy
+// totals count up with the power drawn. Registers outside of the measurement
+// block read back what was written to them, or fail with an illegal address
+// exception if nothing was. The measurement block can also be read as input
+// registers.
+type Simulator struct {
+	now func() time.Time
+
+	mu             sync.Mutex
+	last           time.Time
+	activeEnergy   float64 // kWh
+	reactiveEnergy float64 // kvarh
+	clockOffset    int64   // Device clock minus the system clock in seconds, if set
+	clockSet       bool
+	holding        map[uint16]uint16
+}
+
+// NewSimulator returns a simulated meter with some energy already counted
+func NewSimulator() *Simulator {
+	return &Simulator{
+		now:            time.Now,
+		activeEnergy:   1000 + rand.Float64()*1000,
+		reactiveEnergy: 100 + rand.Float64()*100,
+		holding:        map[uint16]uint16{},
+	}
+}
+
+// simLoad returns the current drawn at t, low at night and peaking in the
+// evening
+func simLoad(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return 3 + 5*(1-math.Cos(2*math.Pi*(hour-7)/24))/2
+}
+
+// block returns the measurement registers at the current time, counting the
+// energy drawn since the last call. s.mu must be held.
+func (s *Simulator) block() []byte {
+	now := s.now()
+	t := float64(now.UnixNano()) / float64(time.Second)
+	voltage := 230 + 3*math.Sin(2*math.Pi*t/600) + rand.Float64() - 0.5
+	current := simLoad(now) + rand.Float64()*0.2
+	frequency := 50 + 0.05*math.Sin(2*math.Pi*t/60)
+	pf := 0.95
+	apparent := voltage * current
+	active := apparent * pf
+	reactive := apparent * math.Sin(math.Acos(pf))
+	temperature := 30 + 5*math.Sin(2*math.Pi*t/3600)
+
+	if !s.last.IsZero() && now.After(s.last) {
+		hours := now.Sub(s.last).Hours()
+		s.activeEnergy += active / 1000 * hours
+		s.reactiveEnergy += reactive / 1000 * hours
+	}
+	s.last = now
+
+	data := make([]byte, readSize*2)
+	binary.BigEndian.PutUint16(data[VoltageReg:], uint16(math.Round(voltage*10)))
+	binary.BigEndian.PutUint16(data[CurrentReg:], uint16(math.Round(current*10)))
+	binary.BigEndian.PutUint16(data[FrequencyReg:], uint16(math.Round(frequency*10)))
+	binary.BigEndian.PutUint16(data[ActivePowerReg:], uint16(int16(math.Round(active))))
+	binary.BigEndian.PutUint16(data[ReactivePowerReg:], uint16(int16(math.Round(reactive))))
+	binary.BigEndian.PutUint16(data[ApparentPowerReg:], uint16(math.Round(apparent)))
+	binary.BigEndian.PutUint16(data[PowerFactorReg:], uint16(int16(math.Round(pf*1000))))
+	binary.BigEndian.PutUint32(data[ActiveEnergyReg:], uint32(s.activeEnergy*100))
+	binary.BigEndian.PutUint32(data[ReactiveEnergyReg:], uint32(s.reactiveEnergy*100))
+	if s.clockSet {
+		binary.BigEndian.PutUint64(data[TimeReg:], uint64(now.Unix()+s.clockOffset))
+	}
+	binary.BigEndian.PutUint16(data[TemperatureReg:], uint16(int16(math.Round(temperature*10))))
+	return data
+}
+
+func illegalAddress(function byte) error {
+	return &modbus.ModbusError{FunctionCode: function, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+}
+
+func illegalFunction(function byte) error {
+	return &modbus.ModbusError{FunctionCode: function, ExceptionCode: modbus.ExceptionCodeIllegalFunction}
+}
+
+// ReadHoldingRegisters reads within the measurement block, or registers
+// outside of it that were written before
+func (s *Simulator) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int(address)+int(quantity) <= readSize {
+		return s.block()[address*2 : (address+quantity)*2], nil
+	}
+	data := make([]byte, 0, quantity*2)
+	for r := address; r < address+quantity; r++ {
+		v, ok := s.holding[r]
+		if !ok {
+			return nil, illegalAddress(modbus.FuncCodeReadHoldingRegisters)
+		}
+		data = binary.BigEndian.AppendUint16(data, v)
+	}
+	return data, nil
+}
+
+// WriteSingleRegister stores value for registers outside of the measurement
+// block
+func (s *Simulator) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if address < readSize {
+		return nil, illegalAddress(modbus.FuncCodeWriteSingleRegister)
+	}
+	s.holding[address] = value
+	return binary.BigEndian.AppendUint16(nil, value), nil
+}
+
+// WriteMultipleRegisters sets the device clock, or stores the values for
+// registers outside of the measurement block
+func (s *Simulator) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(value) != int(quantity)*2 {
+		return nil, &modbus.ModbusError{FunctionCode: modbus.FuncCodeWriteMultipleRegisters, ExceptionCode: modbus.ExceptionCodeIllegalDataValue}
+	}
+	switch {
+	case address == TimeReg/2 && quantity == clockSize:
+		s.clockOffset = int64(binary.BigEndian.Uint64(value)) - s.now().Unix()
+		s.clockSet = true
+	case int(address) >= readSize:
+		for i := uint16(0); i < quantity; i++ {
+			s.holding[address+i] = binary.BigEndian.Uint16(value[i*2:])
+		}
+	default:
+		return nil, illegalAddress(modbus.FuncCodeWriteMultipleRegisters)
+	}
+	return binary.BigEndian.AppendUint16(nil, quantity), nil
+}
+
+// ReadCoils is not supported by the meter
+func (s *Simulator) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeReadCoils)
+}
+
+// ReadDiscreteInputs is not supported by the meter
+func (s *Simulator) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeReadDiscreteInputs)
+}
+
+// WriteSingleCoil is not supported by the meter
+func (s *Simulator) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeWriteSingleCoil)
+}
+
+// WriteMultipleCoils is not supported by the meter
+func (s *Simulator) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeWriteMultipleCoils)
+}
+
+// ReadInputRegisters reads within the measurement block, for meters
+// configured with -regType input
+func (s *Simulator) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int(address)+int(quantity) > readSize {
+		return nil, illegalAddress(modbus.FuncCodeReadInputRegisters)
+	}
+	return s.block()[address*2 : (address+quantity)*2], nil
+}
+
+// ReadWriteMultipleRegisters is not supported by the meter
+func (s *Simulator) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeReadWriteMultipleRegisters)
+}
+
+// MaskWriteRegister is not supported by the meter
+func (s *Simulator) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeMaskWriteRegister)
+}
+
+// ReadFIFOQueue is not supported by the meter
+func (s *Simulator) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return nil, illegalFunction(modbus.FuncCodeReadFIFOQueue)
+}
@@ -0,0 +1,7 @@
+package logger
+
+// Sink receives every successful reading of a Logger. Write is called from
+// the poll loop, so it should return quickly.
+type Sink interface {
+	Write(Reading) error
+}
@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// timestampCollector exports the measurement gauges stamped with the time
+// they were last set by a read, instead of the scrape time. Prometheus
+// treats samples with an old timestamp as stale after 5 minutes, so poll
+// rates slower than that leave gaps.
+type timestampCollector struct {
+	l *Logger
+}
+
+func (c timestampCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range c.l.gauges {
+		g.describe(ch)
+	}
+}
+
+func (c timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	t := c.l.sampleTime.Load()
+	for _, g := range c.l.gauges {
+		if !g.exported() {
+			continue
+		}
+		for _, m := range g.metrics() {
+			if t == 0 {
+				ch <- m
+				continue
+			}
+			ch <- prometheus.NewMetricWithTimestamp(time.Unix(0, t), m)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package logger
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	defaultSagThreshold   = 0.9 // Fraction of the nominal voltage below which the voltage sags
+	defaultSwellThreshold = 1.1 // Fraction of the nominal voltage above which the voltage swells
+)
+
+// voltageEvents counts sags and swells of the mains voltage. Each event is
+// counted once on entry, no matter how many polls it lasts for.
+type voltageEvents struct {
+	sags       prometheus.Counter
+	swells     prometheus.Counter
+	sagLimit   float64
+	swellLimit float64
+	inSag      bool
+	inSwell    bool
+}
+
+func newVoltageEvents(label map[string]string, sagLimit, swellLimit float64) *voltageEvents {
+	return &voltageEvents{
+		sags: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "mains_voltage_sag_total",
+			Help:        "Mains voltage sags",
+			ConstLabels: label,
+		}),
+		swells: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "mains_voltage_swell_total",
+			Help:        "Mains voltage swells",
+			ConstLabels: label,
+		}),
+		sagLimit:   sagLimit,
+		swellLimit: swellLimit,
+	}
+}
+
+func (e *voltageEvents) update(voltage float64) {
+	sag := voltage < e.sagLimit
+	if sag && !e.inSag {
+		e.sags.Inc()
+	}
+	e.inSag = sag
+
+	swell := voltage > e.swellLimit
+	if swell && !e.inSwell {
+		e.swells.Inc()
+	}
+	e.inSwell = swell
+}